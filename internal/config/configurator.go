@@ -26,13 +26,30 @@ const (
 	MAIL_USERNAME            = "MAIL_USERNAME"
 	MAIL_PASSWORD            = "MAIL_PASSWORD"
 	MAIL_COUNT_OF_MAILS      = "MAIL_COUNT_OF_MAILS"
+
+	// Ограничения парсера заявок, незаданное значение - значение по умолчанию из пакета parser
+	PARSER_SHEET_NAME             = "PARSER_SHEET_NAME"
+	PARSER_MAX_FILE_SIZE          = "PARSER_MAX_FILE_SIZE"
+	PARSER_MAX_UNZIP_SIZE         = "PARSER_MAX_UNZIP_SIZE"
+	PARSER_MAX_COMPRESSION_RATIO  = "PARSER_MAX_COMPRESSION_RATIO"
+	PARSER_MAX_ROWS               = "PARSER_MAX_ROWS"
+	PARSER_MAX_CELL_LEN           = "PARSER_MAX_CELL_LEN"
+	PARSER_MAX_SHEETS             = "PARSER_MAX_SHEETS"
+	PARSER_MAX_LEN_OF_ROW         = "PARSER_MAX_LEN_OF_ROW"
+	PARSER_LONG_ROWS_BEFORE_BLOCK = "PARSER_LONG_ROWS_BEFORE_BLOCK"
+	PARSER_TRANSLITERATE          = "PARSER_TRANSLITERATE"
+
+	// Как часто перечитывать категории karate_category, помимо уведомлений от БД. 0 - значение по умолчанию
+	KARATE_CATEGORY_RELOAD_MINUTES = "KARATE_CATEGORY_RELOAD_MINUTES"
 )
 
 type Entity struct {
-	App  Application `mapstructure:",squash"`
-	DB   Database    `mapstructure:",squash"`
-	Jag  Jaeger      `mapstructure:",squash"`
-	Mail Mail        `mapstructure:",squash"`
+	App    Application `mapstructure:",squash"`
+	DB     Database    `mapstructure:",squash"`
+	Jag    Jaeger      `mapstructure:",squash"`
+	Mail   Mail        `mapstructure:",squash"`
+	Parser Parser      `mapstructure:",squash"`
+	Karate Karate      `mapstructure:",squash"`
 }
 
 func NewConfig() (*Entity, error) {
@@ -97,6 +114,23 @@ func NewConfig() (*Entity, error) {
 
 		config.Jag = Jaeger{viper.GetString(JAG_DSN)}
 
+		config.Parser = Parser{
+			SheetName:                   viper.GetString(PARSER_SHEET_NAME),
+			MaxFileSize:                 viper.GetInt64(PARSER_MAX_FILE_SIZE),
+			MaxUnzipSize:                viper.GetInt64(PARSER_MAX_UNZIP_SIZE),
+			MaxCompressionRatio:         viper.GetFloat64(PARSER_MAX_COMPRESSION_RATIO),
+			MaxRows:                     viper.GetInt(PARSER_MAX_ROWS),
+			MaxLenOfCell:                viper.GetInt(PARSER_MAX_CELL_LEN),
+			MaxSheets:                   viper.GetInt(PARSER_MAX_SHEETS),
+			MaxLenOfRow:                 viper.GetInt(PARSER_MAX_LEN_OF_ROW),
+			CountsOfLongRowsBeforeBlock: viper.GetInt(PARSER_LONG_ROWS_BEFORE_BLOCK),
+			Transliterate:               viper.GetBool(PARSER_TRANSLITERATE),
+		}
+
+		config.Karate = Karate{
+			CategoryReloadMinutes: viper.GetInt(KARATE_CATEGORY_RELOAD_MINUTES),
+		}
+
 		return config, nil
 	}
 
@@ -143,6 +177,23 @@ type Jaeger struct {
 	Dsn string `mapstructure:"JAG_DSN"`
 }
 
+type Parser struct {
+	SheetName                   string  `mapstructure:"PARSER_SHEET_NAME"`
+	MaxFileSize                 int64   `mapstructure:"PARSER_MAX_FILE_SIZE"`
+	MaxUnzipSize                int64   `mapstructure:"PARSER_MAX_UNZIP_SIZE"`
+	MaxCompressionRatio         float64 `mapstructure:"PARSER_MAX_COMPRESSION_RATIO"`
+	MaxRows                     int     `mapstructure:"PARSER_MAX_ROWS"`
+	MaxLenOfCell                int     `mapstructure:"PARSER_MAX_CELL_LEN"`
+	MaxSheets                   int     `mapstructure:"PARSER_MAX_SHEETS"`
+	MaxLenOfRow                 int     `mapstructure:"PARSER_MAX_LEN_OF_ROW"`
+	CountsOfLongRowsBeforeBlock int     `mapstructure:"PARSER_LONG_ROWS_BEFORE_BLOCK"`
+	Transliterate               bool    `mapstructure:"PARSER_TRANSLITERATE"`
+}
+
+type Karate struct {
+	CategoryReloadMinutes int `mapstructure:"KARATE_CATEGORY_RELOAD_MINUTES"`
+}
+
 type Mail struct {
 	Hostname     []string
 	Port         string
@@ -2,42 +2,364 @@ package mail
 
 import (
 	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"github.com/Geniuskaa/micro_registration/internal/parser"
+	"github.com/Geniuskaa/micro_registration/internal/sports/karate"
 	"go.uber.org/zap"
 	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/smtp"
+	"net/textproto"
+	"sort"
 	"strings"
 )
 
 const (
-	SMTP_PORT = 587
+	SMTP_PORT       = 587
+	BASE64_LINE_LEN = 76
 )
 
 type serviceResponseDTO struct {
-	Err               error
-	CountOfFailedRows int
-	ErrsOfFailedRows  []error
-	AddedParticipants []string
-	CountOfAddedParts int
+	Err                 error
+	CountOfFailedRows   int
+	ErrsOfFailedRows    []error
+	AddedParticipants   []string
+	CountOfAddedParts   int
+	UpdatedParticipants []string
+	CountOfUpdatedParts int
+	RowErrors           []parser.RowError // Построчные ошибки, найденные парсером в файле заявки
+	Message             string            // Понятное пользователю описание ошибки, из-за которой файл отклонён целиком
+	Tolerance           *toleranceDTO     // Применённый к заявке допуск по ошибкам, nil - если до проверки не дошло
+	CategoryAssignments []categoryAssignmentDTO
+	Teams               []teamDTO
+	Outcomes            []rowOutcomeDTO // Итог по каждой строке заявки
+	TemplateAttached    bool            // К письму приложен шаблон заявки на соревнование
+	AnnotatedAttached   bool            // К письму приложена заявка с отмеченными ошибками
 }
 
-func parseTemplate(subject string, data interface{}, templateFileName ...string) ([]byte, error) {
+// categoryAssignmentDTO - весовая категория кумите, которую соревнование подобрало участнику по весу.
+type categoryAssignmentDTO struct {
+	Row      int
+	FullName string
+	Weight   float32
+	Declared string // Категория из заявки, "" - если не была указана
+	Assigned string
+	Conflict bool // В заявке указана другая категория
+}
+
+// toleranceDTO - сколько ошибок было в заявке и сколько допускает соревнование.
+type toleranceDTO struct {
+	Strict      bool
+	Threshold   float64
+	PercentErrs float64
+	InvalidRows int
+	TotalRows   int
+}
+
+// duplicateRowErrors сообщает об участниках, которые уже зарегистрированы на соревнование и не были обновлены.
+func duplicateRowErrors(duplicates []karate.Participant) []parser.RowError {
+	rowErrs := make([]parser.RowError, 0, len(duplicates))
+	for _, p := range duplicates {
+		rowErrs = append(rowErrs, parser.RowError{
+			Row:   p.Row,
+			Value: p.FullName,
+			Code:  parser.ERR_CODE_DUPLICATE,
+			Message: fmt.Sprintf("Участник уже зарегистрирован на соревнование. Чтобы изменить его данные, "+
+				"отправьте заявку повторно, добавив в тему письма слово \"%s\"", CORRECTIONS_KEY),
+		})
+	}
+	return rowErrs
+}
+
+// failedRowErrors сообщает об участниках, которых не удалось зарегистрировать: весовая категория не нашлась среди
+// категорий соревнования или не подходит по весу, для возраста и пола участника нет категории, у команды ката
+// неверный состав, либо участника не удалось записать в БД.
+func failedRowErrors(failed []karate.FailedParticipant, columns map[string]string) []parser.RowError {
+	rowErrs := make([]parser.RowError, 0, len(failed))
+	for _, f := range failed {
+		var catErr *karate.CategoryError
+		var teamErr *karate.TeamError
+		var writeErr *karate.WriteError
+		var noCatErr *karate.NoCategoryError
+		switch {
+		case errors.As(f.Err, &catErr):
+			rowErrs = append(rowErrs, parser.RowError{
+				Row:     f.Participant.Row,
+				Column:  columns[parser.COL_CATEGORY],
+				Value:   karate.FormatWeightCategory(f.Participant.Category),
+				Code:    parser.ERR_CODE_BAD_CATEGORY,
+				Message: catErr.Error(),
+			})
+		case errors.As(f.Err, &teamErr):
+			rowErrs = append(rowErrs, parser.RowError{
+				Row:     f.Participant.Row,
+				Column:  columns[parser.COL_KATA_TEAM],
+				Value:   f.Participant.KataTeam,
+				Code:    parser.ERR_CODE_BAD_TEAM,
+				Message: teamErr.Error(),
+			})
+		case errors.As(f.Err, &noCatErr):
+			rowErrs = append(rowErrs, parser.RowError{
+				Row:     f.Participant.Row,
+				Value:   f.Participant.FullName,
+				Code:    parser.ERR_CODE_NO_CATEGORY,
+				Message: noCatErr.Error(),
+			})
+		case errors.As(f.Err, &writeErr):
+			rowErrs = append(rowErrs, parser.RowError{
+				Row:     f.Participant.Row,
+				Value:   f.Participant.FullName,
+				Code:    parser.ERR_CODE_NOT_SAVED,
+				Message: writeErr.Error(),
+			})
+		}
+	}
+	return rowErrs
+}
+
+// rowOutcomeDTO - что стало с участником из строки заявки.
+type rowOutcomeDTO struct {
+	Row      int
+	FullName string
+	Status   string
+	Failed   bool // Участник не зарегистрирован
+}
+
+// outcomeStatuses - описания karate.RowOutcome.Status для письма.
+var outcomeStatuses = map[string]string{
+	karate.OUTCOME_ADDED:       "Зарегистрирован",
+	karate.OUTCOME_UPDATED:     "Данные обновлены",
+	karate.OUTCOME_DUPLICATE:   "Уже зарегистрирован, данные не изменены",
+	karate.OUTCOME_FAILED:      "Не зарегистрирован, см. ошибки",
+	karate.OUTCOME_ROLLED_BACK: "Не зарегистрирован: заявка не сохранена",
+}
+
+func rowOutcomesOf(outcomes []karate.RowOutcome) []rowOutcomeDTO {
+	dto := make([]rowOutcomeDTO, 0, len(outcomes))
+	for _, o := range outcomes {
+		dto = append(dto, rowOutcomeDTO{
+			Row:      o.Row,
+			FullName: o.FullName,
+			Status:   outcomeStatuses[o.Status],
+			Failed:   o.Status != karate.OUTCOME_ADDED && o.Status != karate.OUTCOME_UPDATED,
+		})
+	}
+	return dto
+}
+
+// teamDTO - команда группового ката и её участники.
+type teamDTO struct {
+	Name    string
+	Members []string
+}
+
+func teamsOf(teams []karate.Team) []teamDTO {
+	dto := make([]teamDTO, 0, len(teams))
+	for _, t := range teams {
+		members := make([]string, 0, len(t.Members))
+		for _, p := range t.Members {
+			members = append(members, p.FullName)
+		}
+		dto = append(dto, teamDTO{Name: t.Name, Members: members})
+	}
+	return dto
+}
+
+func categoryAssignmentsOf(assignments []karate.CategoryAssignment) []categoryAssignmentDTO {
+	dto := make([]categoryAssignmentDTO, 0, len(assignments))
+	for _, a := range assignments {
+		dto = append(dto, categoryAssignmentDTO{
+			Row:      a.Participant.Row,
+			FullName: a.Participant.FullName,
+			Weight:   a.Participant.Weight,
+			Declared: karate.FormatWeightCategory(a.Declared),
+			Assigned: karate.FormatWeightCategory(a.Assigned),
+			Conflict: a.Conflict(),
+		})
+	}
+	sort.Slice(dto, func(i, j int) bool {
+		return dto[i].Row < dto[j].Row
+	})
+	return dto
+}
+
+func toleranceOf(policy karate.UploadPolicy, response *parser.Response) *toleranceDTO {
+	return &toleranceDTO{
+		Strict:      policy.Strict,
+		Threshold:   policy.ErrorThreshold,
+		PercentErrs: response.PercentErrs,
+		InvalidRows: response.InvalidRows,
+		TotalRows:   response.TotalRows,
+	}
+}
+
+// userMessageOf возвращает описание ошибки парсера для письма пользователю. Для внутренних ошибок вернёт "".
+func userMessageOf(err error) string {
+	var missingCols *parser.MissingColumnsError
+	var limitErr *parser.LimitError
+	switch {
+	case errors.As(err, &missingCols):
+		return missingCols.Error()
+	case errors.As(err, &limitErr):
+		return limitErr.Error()
+	case errors.Is(err, parser.ErrNoParticipants):
+		return parser.ErrNoParticipants.Error()
+	case errors.Is(err, parser.ErrHeaderNotFound):
+		return parser.ErrHeaderNotFound.Error()
+	case errors.Is(err, parser.ErrUUIDNotFound):
+		return parser.ErrUUIDNotFound.Error()
+	case errors.Is(err, parser.ErrInvalidUUID):
+		return parser.ErrInvalidUUID.Error()
+	case errors.Is(err, parser.ErrUnsupportedFormat):
+		return parser.ErrUnsupportedFormat.Error()
+	case errors.Is(err, parser.ErrSheetNotFound):
+		return parser.ErrSheetNotFound.Error()
+	case errors.Is(err, parser.ErrXlsEncrypted):
+		return parser.ErrXlsEncrypted.Error()
+	case errors.Is(err, parser.ErrXlsUnsupported):
+		return parser.ErrXlsUnsupported.Error()
+	case errors.Is(err, karate.ErrUploadRolledBack):
+		return karate.ErrUploadRolledBack.Error()
+	case errors.Is(err, karate.ErrCompetitionNotFound):
+		return "Соревнование с UUID, указанным в заявке, не найдено. Проверьте UUID в заявке"
+	default:
+		return ""
+	}
+}
+
+func parseTemplate(subject string, data interface{}, attachments []attachment, templateFileName ...string) ([]byte, error) {
 
 	t, err := template.ParseFiles(templateFileName...)
 	if err != nil {
 		return nil, err
 	}
+	body := new(bytes.Buffer)
+	if err = t.Execute(body, data); err != nil {
+		return nil, err
+	}
+
+	return buildMessage(subject, body.Bytes(), attachments)
+}
+
+// attachment - файл, приложенный к письму.
+type attachment struct {
+	filename    string
+	contentType string
+	data        []byte
+}
+
+// buildMessage собирает письмо с HTML-телом. Если есть вложения, письмо собирается как multipart/mixed:
+// первая часть - HTML, за ней файлы в base64.
+func buildMessage(subject string, html []byte, attachments []attachment) ([]byte, error) {
 	buf := new(bytes.Buffer)
-	mimeHeaders := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-	buf.Write([]byte(fmt.Sprintf("Subject: %s \n%s\n\n", subject, mimeHeaders)))
+	if len(attachments) == 0 {
+		mimeHeaders := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
+		buf.Write([]byte(fmt.Sprintf("Subject: %s \n%s\n\n", subject, mimeHeaders)))
+		buf.Write(html)
+		return buf.Bytes(), nil
+	}
 
-	if err = t.Execute(buf, data); err != nil {
+	mw := multipart.NewWriter(buf)
+	buf.WriteString(fmt.Sprintf("Subject: %s\r\nMIME-Version: 1.0\r\nContent-Type: %s\r\n\r\n", subject,
+		mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mw.Boundary()})))
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {`text/html; charset="UTF-8"`},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("mw.CreatePart failed: %w", err)
+	}
+	if err = writeBase64(part, html); err != nil {
 		return nil, err
 	}
 
+	for _, a := range attachments {
+		part, err = mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(a.contentType, map[string]string{"name": a.filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("mw.CreatePart failed: %w", err)
+		}
+		if err = writeBase64(part, a.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = mw.Close(); err != nil {
+		return nil, fmt.Errorf("mw.Close failed: %w", err)
+	}
 	return buf.Bytes(), nil
 }
 
+// writeBase64 пишет данные в base64 строками по 76 символов, как того требует RFC 2045.
+func writeBase64(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := BASE64_LINE_LEN
+		if n > len(encoded) {
+			n = len(encoded)
+		}
+		if _, err := io.WriteString(w, encoded[:n]+"\r\n"); err != nil {
+			return fmt.Errorf("io.WriteString failed: %w", err)
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+// annotatedFor возвращает присланную заявку с отмеченными ошибками. Если отметить ошибки не удалось, письмо уходит
+// без вложения: список ошибок есть и в самом письме.
+func (s *Service) annotatedFor(fp fileParser, data []byte, filename string, resp *parser.Response,
+	rowErrs []parser.RowError) (attachments []attachment) {
+	if len(rowErrs) == 0 {
+		return nil
+	}
+
+	// Файл уже разобран парсером, но excelize читает его заново, и паника здесь не должна остановить почтовый ящик
+	defer func() {
+		if rec := recover(); rec != nil {
+			s.logger.Error("fp.Annotate panicked", zap.Any("panic", rec), zap.String("filename", filename),
+				zap.Stack("stack"))
+			attachments = nil
+		}
+	}()
+
+	annotated, err := fp.Annotate(data, filename, resp.Sheet, rowErrs)
+	if err != nil {
+		s.logger.Error("fp.Annotate failed", zap.Error(err), zap.String("filename", filename))
+		return nil
+	}
+
+	return []attachment{{filename: parser.AnnotatedFilename(filename), contentType: parser.XLSX_CONTENT_TYPE,
+		data: annotated}}
+}
+
+// templateFor собирает шаблон заявки на соревнование для вложения в письмо. Если соревнование не найдено
+// или шаблон собрать не удалось, письмо уходит без вложения.
+func (s *Service) templateFor(c karate.Competition, err error) []attachment {
+	if err != nil {
+		if !errors.Is(err, karate.ErrCompetitionNotFound) {
+			s.logger.Warn("Competition for template not found", zap.Error(err))
+		}
+		return nil
+	}
+
+	data, err := parser.KarateTemplate(c, s.karateServ.KumiteCategories(c.UUID))
+	if err != nil {
+		s.logger.Error("parser.KarateTemplate failed", zap.Error(err))
+		return nil
+	}
+
+	return []attachment{{filename: parser.TemplateFilename(c), contentType: parser.XLSX_CONTENT_TYPE, data: data}}
+}
+
 func (s *Service) mailboxAuth(mailboxData connectionCredentials) *smtp.Auth {
 
 	user := mailboxData.username
@@ -50,7 +372,7 @@ func (s *Service) mailboxAuth(mailboxData connectionCredentials) *smtp.Auth {
 	return &auth
 }
 
-func (s *Service) responseToLetter(to string, subject string, mailboxData connectionCredentials, auth *smtp.Auth, resp serviceResponseDTO) error {
+func (s *Service) responseToLetter(to string, subject string, mailboxData connectionCredentials, auth *smtp.Auth, resp serviceResponseDTO, attachments ...attachment) error {
 
 	addr := fmt.Sprintf("%s:%d", strings.ReplaceAll(mailboxData.hostname, "imap", "smtp"), SMTP_PORT)
 	t := []string{to}
@@ -58,9 +380,9 @@ func (s *Service) responseToLetter(to string, subject string, mailboxData connec
 	var err error
 	var body []byte
 	if resp.Err != nil {
-		body, err = parseTemplate(subject, nil, "pkg/mail/templates/negativeFeedback.html")
+		body, err = parseTemplate(subject, resp, attachments, "pkg/mail/templates/negativeFeedback.html")
 	} else {
-		body, err = parseTemplate(subject, resp, "pkg/mail/templates/positiveFeedback.html")
+		body, err = parseTemplate(subject, resp, attachments, "pkg/mail/templates/positiveFeedback.html")
 	}
 	if err != nil {
 		s.logger.Error("responseToLetter failed", zap.Error(err))
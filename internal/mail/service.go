@@ -1,6 +1,7 @@
 package mail
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 	"go.uber.org/zap"
 	"io"
 	"regexp"
+	"runtime/debug"
 	"strings"
 	"time"
 )
@@ -22,6 +24,7 @@ var (
 	errWithDBWriting     = errors.New("Проблема с записью данных в БД")
 	errWithIncorrectData = errors.New("Too many mistakes in file.")
 	errWithResponseType  = errors.New("Undefined type of response!")
+	errParserPanic       = errors.New("Parser panicked")
 )
 
 const (
@@ -40,9 +43,6 @@ const (
 	// опред-ым интервалом. Если попыткы были безуспешны система запишет ошибку в канал.
 	COUNT_OF_RECONNECTIONS = 5
 	RECONNECT_INTERVAL     = time.Minute * 10
-
-	// Расширение файла, файлы с которым обрабатывает наш парсер.
-	FILE_EXTENSION = ".xlsx"
 )
 
 type Service struct {
@@ -50,11 +50,15 @@ type Service struct {
 	countOfmailsPerRequest uint32
 	logger                 *zap.Logger
 	karateServ             *karate.Service
+	parser                 fileParser
 	ctx                    context.Context
 }
 
+// Парсер принимает файл любого поддерживаемого формата (xlsx, xls, ods, csv), формат определяет сам.
 type fileParser interface {
-	ParseXlsx(r io.Reader) (*parser.Response, error)
+	Parse(r io.Reader, filename string) (*parser.Response, error)
+	// Annotate возвращает копию заявки data с отмеченными ошибками rowErrs в формате xlsx.
+	Annotate(data []byte, filename, sheet string, rowErrs []parser.RowError) ([]byte, error)
 }
 
 // Структура закрепленная за своим почтовым ящиком. 1 ящику 1 структура.
@@ -90,7 +94,23 @@ func NewService(conf *config.Entity, logger *zap.Logger, karateServ *karate.Serv
 		}
 	}
 
-	return &Service{mailboxes: mailBoxes, countOfmailsPerRequest: conf.Mail.CountOfMails, logger: logger, karateServ: karateServ}
+	fp := parser.Impl{
+		SheetName: conf.Parser.SheetName,
+		Limits: parser.Limits{
+			MaxFileSize:                 conf.Parser.MaxFileSize,
+			MaxUnzipSize:                conf.Parser.MaxUnzipSize,
+			MaxCompressionRatio:         conf.Parser.MaxCompressionRatio,
+			MaxRows:                     conf.Parser.MaxRows,
+			MaxLenOfCell:                conf.Parser.MaxLenOfCell,
+			MaxSheets:                   conf.Parser.MaxSheets,
+			MaxLenOfRow:                 conf.Parser.MaxLenOfRow,
+			CountsOfLongRowsBeforeBlock: conf.Parser.CountsOfLongRowsBeforeBlock,
+		},
+		Transliterate: conf.Parser.Transliterate,
+	}
+
+	return &Service{mailboxes: mailBoxes, countOfmailsPerRequest: conf.Mail.CountOfMails, logger: logger,
+		karateServ: karateServ, parser: fp}
 }
 
 // Ф-ци, которая динамически меняет кол-во минимально читаемых писем. Для изменения этого числа нужно в конфиге
@@ -107,7 +127,7 @@ func (s *Service) CheckMails() chan error {
 		go func(errChn chan error, connData connectionCredentials, logger *zap.Logger) {
 			var err error
 			for i := 0; i < COUNT_OF_RECONNECTIONS; i++ {
-				err = s.readLetters(connData, parser.Impl{})
+				err = s.readLettersSafely(connData, s.parser)
 				if err == nil {
 					i = 0
 					time.Sleep(time.Hour)
@@ -129,7 +149,33 @@ func (s *Service) CheckMails() chan error {
 	return errChn
 }
 
-func (s *Service) readLetters(conn connectionCredentials, parser fileParser) error {
+// readLettersSafely не даёт панике при обработке писем завершить горутину почтового ящика: паника превращается
+// в ошибку, после которой горутина переподключается, как после любого другого сбоя.
+func (s *Service) readLettersSafely(conn connectionCredentials, fp fileParser) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger.Error("readLetters panicked", zap.Any("panic", r), zap.String("mail-box: ", conn.username),
+				zap.Stack("stack"))
+			err = fmt.Errorf("readLetters panicked: %v", r)
+		}
+	}()
+
+	return s.readLetters(conn, fp)
+}
+
+// parseFile разбирает вложение. Файлы приходят от кого угодно, и паника парсера на одном файле не должна мешать
+// обработке остальных писем, поэтому она возвращается как ошибка errParserPanic.
+func parseFile(fp fileParser, r io.Reader, filename string) (resp *parser.Response, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("%w: %v\n%s", errParserPanic, rec, debug.Stack())
+		}
+	}()
+
+	return fp.Parse(r, filename)
+}
+
+func (s *Service) readLetters(conn connectionCredentials, fp fileParser) error {
 
 	// Connect to server
 	c, err := client.DialTLS(fmt.Sprintf("%s:%s", conn.hostname, conn.port), nil)
@@ -241,9 +287,12 @@ func (s *Service) readLetters(conn connectionCredentials, parser fileParser) err
 		}
 
 		// Вторым аргументов идет дата, если не так написана - кидаем ошибку
-		compDateStr := strings.Split(subject, " ")
+		compDateStr := strings.Fields(subject)
 
-		isItDate, err := regexp.MatchString("[0-9]{2}.[0-9]{2}.[0-9]{4}", compDateStr[1])
+		isItDate := false
+		if len(compDateStr) > 1 {
+			isItDate, _ = regexp.MatchString("[0-9]{2}.[0-9]{2}.[0-9]{4}", compDateStr[1])
+		}
 		if !isItDate {
 			s.logger.Warn("Second argument of letter subject is not date or it has written incorrect",
 				zap.String("source", "readLetters"), zap.String("letter-info",
@@ -322,7 +371,7 @@ func (s *Service) readLetters(conn connectionCredentials, parser fileParser) err
 					countOfMailWithErrs++
 					continue
 				}
-				if !strings.HasSuffix(filename, FILE_EXTENSION) {
+				if !parser.IsSupported(filename) {
 					s.logger.Error("Filename has wrong file extension", zap.String("letter-info",
 						fmt.Sprintf("msg sent: %s from: %s to: %s",
 							dateOfMsg.Format("02-01-2006"), f, t)))
@@ -333,12 +382,18 @@ func (s *Service) readLetters(conn connectionCredentials, parser fileParser) err
 				i--
 
 				// Ф-ция пока без использования горутин. Позже если появится вариант лучше - заменим.
-				response, err := parser.ParseXlsx(p.Body)
+				// Копия файла нужна, чтобы вернуть тренеру заявку с отмеченными ошибками. Парсер читает не больше
+				// Limits.MaxFileSize, так что и копия не больше.
+				raw := new(bytes.Buffer)
+				response, err := parseFile(fp, io.TeeReader(p.Body, raw), filename)
 				if err != nil {
-					s.logger.Error("Err during parsing file", zap.Error(fmt.Errorf("ParseXlsx failed: %w", err)))
+					s.logger.Error("Err during parsing file", zap.Error(fmt.Errorf("Parse failed: %w", err)))
 
 					// TODO: отправить пользователю информацию о том, что его файл некорректный
-					errOfResp := s.responseToLetter(f, subject, conn, auth, serviceResponseDTO{Err: err})
+					// Шаблон поможет заполнить заявку заново, если по дате из темы письма понятно, о каком соревновании речь
+					attachments := s.templateFor(s.karateServ.CompetitionByDate(compDate))
+					errOfResp := s.responseToLetter(f, subject, conn, auth, serviceResponseDTO{Err: err,
+						Message: userMessageOf(err), TemplateAttached: len(attachments) != 0}, attachments...)
 					if errOfResp != nil {
 						// TODO: обработать
 					}
@@ -346,31 +401,86 @@ func (s *Service) readLetters(conn connectionCredentials, parser fileParser) err
 					continue
 				}
 
-				if response.PercentErrs > 50 {
-					s.logger.Error("Too much errors during file parsing",
-						zap.Error(fmt.Errorf("ParseXlsx failed: %w", errWithIncorrectData)))
-					// TODO: отправить пользователю информацию о том, что его файл не корректный
-					//  возвращать кастомный вариант ошибки
-					errOfResp := s.responseToLetter(f, subject, conn, auth, serviceResponseDTO{Err: err})
-					if errOfResp != nil {
-						// TODO: обработать
+				switch response.SportType {
+				case "KARATE":
+					policy, err := s.karateServ.UploadPolicy(response.UUID)
+					if errors.Is(err, karate.ErrCompetitionNotFound) {
+						// В заявке указан UUID несуществующего соревнования. Это ошибка отправителя, а не БД:
+						// отвечаем ему и читаем остальные письма
+						s.logger.Warn("Competition from application not found", zap.String("uuid", response.UUID),
+							zap.String("letter-info", fmt.Sprintf("msg sent: %s from: %s to: %s",
+								dateOfMsg.Format("02-01-2006"), f, t)))
+						attachments := s.templateFor(s.karateServ.CompetitionByDate(compDate))
+						errOfResp := s.responseToLetter(f, subject, conn, auth, serviceResponseDTO{Err: err,
+							Message: userMessageOf(err), TemplateAttached: len(attachments) != 0}, attachments...)
+						if errOfResp != nil {
+							// TODO: обработать
+						}
+						countOfMailWithErrs++
+						continue
+					}
+					if err != nil {
+						s.logger.Error("s.karateServ.UploadPolicy failed: ", zap.Error(err))
+						return errWithDBWriting
 					}
-					countOfMailWithErrs++
-					continue
 
-					// TODO: в таком случае нам лучше не добавлять участников из такого файла, а отправить ответное
-					//  письмо с просьбой изменить данные на корректные
-				}
+					// Допуск по ошибкам задаётся для каждого соревнования, в строгом режиме заявка с любой
+					// ошибкой не принимается
+					if policy.Rejects(response.InvalidRows, response.PercentErrs) {
+						s.logger.Error("Too much errors during file parsing",
+							zap.Error(fmt.Errorf("Parse failed: %w", errWithIncorrectData)),
+							zap.Float64("percent-errs", response.PercentErrs), zap.Bool("strict", policy.Strict))
+						annotated := s.annotatedFor(fp, raw.Bytes(), filename, response, response.RowErrors)
+						attachments := s.templateFor(s.karateServ.Competition(response.UUID))
+						errOfResp := s.responseToLetter(f, subject, conn, auth, serviceResponseDTO{
+							Err:               errWithIncorrectData,
+							RowErrors:         response.RowErrors,
+							Tolerance:         toleranceOf(policy, response),
+							TemplateAttached:  len(attachments) != 0,
+							AnnotatedAttached: len(annotated) != 0,
+						}, append(annotated, attachments...)...)
+						if errOfResp != nil {
+							// TODO: обработать
+						}
+						countOfMailWithErrs++
+						continue
+					}
 
-				switch response.SportType {
-				case "KARATE":
-					karateResp, err := s.karateServ.UploadParticipants(response.Map, response.UUID)
+					club := karate.Club{
+						Name:  response.Meta.Club,
+						Email: response.Meta.CoachEmail,
+						Phone: response.Meta.CoachPhone,
+						City:  response.Meta.City,
+					}
+
+					// Повторно присланных участников обновляем только по письму с пометкой "изменения"
+					updateExisting := strings.Contains(strings.ToLower(subject), CORRECTIONS_KEY)
+
+					karateResp, err := s.karateServ.UploadParticipants(response.Map, response.UUID, club, updateExisting,
+						policy.Mode())
 					if err != nil {
 						s.logger.Error("s.karateServ.UploadParticipants failed: ", zap.Error(err))
 						return errWithDBWriting
 					}
 
-					errOfResp := s.responseToLetter(f, subject, conn, auth, servResponseToDTOConverter(*karateResp))
+					dto := servResponseToDTOConverter(*karateResp)
+					// Копия: срез парсера ещё нужен response, дописывать в его массив нельзя
+					dto.RowErrors = append(append([]parser.RowError(nil), response.RowErrors...),
+						duplicateRowErrors(karateResp.Duplicates)...)
+					dto.RowErrors = append(dto.RowErrors, failedRowErrors(karateResp.FailedParticipants, response.Columns)...)
+					dto.RowErrors = append(dto.RowErrors, failedRowErrors(karateResp.TeamlessMembers, response.Columns)...)
+					dto.Tolerance = toleranceOf(policy, response)
+					if karateResp.RolledBack {
+						// Строгое соревнование не принимает заявку частично, тренер получает письмо об ошибке
+						dto.Err = karate.ErrUploadRolledBack
+						dto.Message = userMessageOf(karate.ErrUploadRolledBack)
+						dto.Tolerance = nil
+					}
+
+					annotated := s.annotatedFor(fp, raw.Bytes(), filename, response, dto.RowErrors)
+					dto.AnnotatedAttached = len(annotated) != 0
+
+					errOfResp := s.responseToLetter(f, subject, conn, auth, dto, annotated...)
 					if errOfResp != nil {
 						fmt.Println("Ошибка отправки письма")
 						//TODO: обработать
@@ -386,7 +496,7 @@ func (s *Service) readLetters(conn connectionCredentials, parser fileParser) err
 						conn.previousMails[newLetter]--
 					}
 				} else {
-					s.logger.Error("Xlsx file parsing err", zap.Error(fmt.Errorf("parser.ParseXlsx failed: %w", err)),
+					s.logger.Error("File parsing err", zap.Error(fmt.Errorf("parser.Parse failed: %w", err)),
 						zap.String("letter-info", fmt.Sprintf("msg sent: %s from: %s to: %s",
 							dateOfMsg.Format("02-01-2006"), f, t)))
 				}
@@ -442,11 +552,16 @@ func servResponseToDTOConverter(resp interface{}) serviceResponseDTO {
 		karResp := resp.(karate.Response)
 
 		return serviceResponseDTO{
-			Err:               nil,
-			CountOfFailedRows: karResp.CountOfFailedRows,
-			ErrsOfFailedRows:  karResp.ErrsOfFailedRows,
-			AddedParticipants: karResp.AddedParticipants,
-			CountOfAddedParts: karResp.CountOfAddedParts,
+			Err:                 nil,
+			CountOfFailedRows:   karResp.CountOfFailedRows,
+			ErrsOfFailedRows:    karResp.ErrsOfFailedRows,
+			AddedParticipants:   karResp.AddedParticipants,
+			CountOfAddedParts:   karResp.CountOfAddedParts,
+			UpdatedParticipants: karResp.UpdatedParticipants,
+			CountOfUpdatedParts: karResp.CountOfUpdatedParts,
+			CategoryAssignments: categoryAssignmentsOf(karResp.CategoryAssignments),
+			Teams:               teamsOf(karResp.Teams),
+			Outcomes:            rowOutcomesOf(karResp.Outcomes),
 		}
 	default:
 		return serviceResponseDTO{Err: fmt.Errorf("servResponseToDTOConverter failed: %w", errWithResponseType)}
@@ -0,0 +1,96 @@
+// Package names приводит ФИО участников к единому виду, чтобы один и тот же спортсмен, записанный в разных
+// заявках по-разному ("Иванов  иван", "ИВАНОВ Иван ", "Ivanov Ivan"), распознавался как один человек.
+package names
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Части отчества, которые пишутся со строчной буквы: "Мамедов Ахмед Рашид оглы"
+var lowerParticles = map[string]bool{"оглы": true, "кызы": true, "улы": true, "уулу": true}
+
+// FullName - ФИО, разобранное на части. Предполагается порядок "Фамилия Имя Отчество", отчества может не быть.
+// Части хранятся в том виде, в каком их записали, с ё и в исходном алфавите, поэтому сравнивать FullName между
+// собой нельзя: "Пётр" и "Петр" дадут разные значения. Для сравнения есть Key.
+type FullName struct {
+	Surname    string
+	Name       string
+	Patronymic string
+}
+
+// Parse разбирает ФИО: убирает лишние пробелы, приводит каждую часть к виду "Иванова-Петрова". Всё, что идёт
+// после имени, считается отчеством.
+func Parse(s string) FullName {
+	parts := strings.Fields(s)
+	for i := range parts {
+		parts[i] = capitalize(parts[i])
+	}
+
+	n := FullName{}
+	switch {
+	case len(parts) == 0:
+	case len(parts) == 1:
+		n.Surname = parts[0]
+	case len(parts) == 2:
+		n.Surname, n.Name = parts[0], parts[1]
+	default:
+		n.Surname, n.Name, n.Patronymic = parts[0], parts[1], strings.Join(parts[2:], " ")
+	}
+
+	return n
+}
+
+// Complete - указаны ли фамилия и имя.
+func (n FullName) Complete() bool {
+	return n.Surname != "" && n.Name != ""
+}
+
+func (n FullName) String() string {
+	return strings.Join(nonEmpty(n.Surname, n.Name, n.Patronymic), " ")
+}
+
+// Latin возвращает ФИО латиницей по правилам транслитерации загранпаспортов (ICAO Doc 9303).
+func (n FullName) Latin() string {
+	return Transliterate(n.String())
+}
+
+// Key - ключ для сравнения ФИО: без учёта регистра, ё/е и алфавита, в котором ФИО записано. Кириллица
+// транслитерируется, а разные варианты латинского написания одних и тех же звуков сводятся к одному. Порядок слов
+// учитывается: "Иванов Иван" и "Иван Иванов" дают разные ключи. Удвоенные буквы не различаются ("Алла" и "Ала"),
+// поэтому совпадение ключей ещё не значит, что это один человек.
+func (n FullName) Key() string {
+	return fold(Transliterate(strings.ToLower(n.String())))
+}
+
+// capitalize приводит слово к виду "Иванов", каждую часть двойной фамилии - отдельно.
+func capitalize(word string) string {
+	lower := strings.ToLower(word)
+	if lowerParticles[lower] {
+		return lower
+	}
+
+	runes := []rune(lower)
+	upperNext := true
+	for i, r := range runes {
+		if upperNext && unicode.IsLetter(r) {
+			runes[i] = unicode.ToUpper(r)
+			upperNext = false
+		}
+		if r == '-' {
+			upperNext = true
+		}
+	}
+
+	return string(runes)
+}
+
+func nonEmpty(parts ...string) []string {
+	res := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p != "" {
+			res = append(res, p)
+		}
+	}
+	return res
+}
@@ -0,0 +1,74 @@
+package names
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want FullName
+	}{
+		{in: "  иванов   иван  ", want: FullName{Surname: "Иванов", Name: "Иван"}},
+		{in: "ИВАНОВА-ПЕТРОВА мария\tсергеевна", want: FullName{Surname: "Иванова-Петрова", Name: "Мария", Patronymic: "Сергеевна"}},
+		{in: "Мамедов Ахмед Рашид ОГЛЫ", want: FullName{Surname: "Мамедов", Name: "Ахмед", Patronymic: "Рашид оглы"}},
+		{in: "Ёлкин Пётр", want: FullName{Surname: "Ёлкин", Name: "Пётр"}},
+		{in: "Иванов", want: FullName{Surname: "Иванов"}},
+		{in: " ", want: FullName{}},
+	}
+	for _, tt := range tests {
+		if got := Parse(tt.in); got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTransliterate(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "Иванов Иван", want: "Ivanov Ivan"},
+		{in: "Ёлкин Пётр", want: "Elkin Petr"},
+		{in: "Щукина Юлия", want: "Shchukina Iuliia"},
+		{in: "Хабибуллин Эльдар", want: "Khabibullin Eldar"},
+		{in: "Ivanov Иван-2", want: "Ivanov Ivan-2"},
+	}
+	for _, tt := range tests {
+		if got := Transliterate(tt.in); got != tt.want {
+			t.Errorf("Transliterate(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{name: "yo", a: "Ёлкин Пётр", b: "Елкин Петр", same: true},
+		{name: "case_and_whitespace", a: "  ИВАНОВ   иван ", b: "Иванов Иван", same: true},
+		{name: "latin", a: "Иванов Иван", b: "Ivanov Ivan", same: true},
+		{name: "latin_variants", a: "Юрьев Юрий", b: "Yuryev Yuriy", same: true},
+		{name: "latin_shch", a: "Щукин Харитон", b: "Schukin Hariton", same: true},
+		{name: "swapped_order", a: "Иванов Иван", b: "Иван Иванов", same: false},
+		{name: "patronymic", a: "Иванов Иван", b: "Иванов Иван Иванович", same: false},
+		// Удвоенные буквы в ключе не различаются, таких тёзок разводят по дате рождения (см. karate.SameBirthDate)
+		{name: "double_letter", a: "Иванова Алла", b: "Иванова Ала", same: true},
+		{name: "other_name", a: "Иванова Алла", b: "Иванова Алина", same: false},
+	}
+	for _, tt := range tests {
+		a, b := Parse(tt.a).Key(), Parse(tt.b).Key()
+		if (a == b) != tt.same {
+			t.Errorf("%s: Key(%q) = %q, Key(%q) = %q, want same = %v", tt.name, tt.a, a, tt.b, b, tt.same)
+		}
+	}
+}
+
+func TestFullNameKeepsYo(t *testing.T) {
+	a, b := Parse("Ёлкин Пётр"), Parse("Елкин Петр")
+	if a == b {
+		t.Errorf("Parse folded ё: %+v", a)
+	}
+	if a.Key() != b.Key() {
+		t.Errorf("Key did not fold ё: %q, %q", a.Key(), b.Key())
+	}
+}
@@ -0,0 +1,62 @@
+package names
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Транслитерация по ICAO Doc 9303, по этим правилам пишутся ФИО в загранпаспортах.
+var icao = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z", 'и': "i",
+	'й': "i", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t",
+	'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "ie", 'ы': "y", 'ь': "",
+	'э': "e", 'ю': "iu", 'я': "ia",
+}
+
+// Разные системы транслитерации пишут одни и те же звуки по-разному: "Yuriy", "Iurii", "Jurij".
+// Для сравнения они сводятся к одному написанию.
+var latinFolds = strings.NewReplacer(
+	"shch", "sh", "sch", "sh",
+	"yu", "iu", "ju", "iu",
+	"ya", "ia", "ja", "ia",
+	"yo", "e", "jo", "e", "ye", "e", "je", "e",
+	"ie", "e",
+	"kh", "h",
+	"x", "ks",
+	"w", "v",
+	"y", "i", "j", "i",
+)
+
+// Transliterate переводит кириллицу в латиницу, сохраняя регистр первой буквы. Остальные символы не меняются.
+func Transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		lat, ok := icao[unicode.ToLower(r)]
+		if !ok {
+			b.WriteRune(r)
+			continue
+		}
+		if unicode.IsUpper(r) && lat != "" {
+			lat = strings.ToUpper(lat[:1]) + lat[1:]
+		}
+		b.WriteString(lat)
+	}
+	return b.String()
+}
+
+// fold сводит латинское написание к виду для сравнения: без вариантов транслитерации и без удвоенных букв
+// ("Iurii" и "Yuri" дают одно и то же).
+func fold(s string) string {
+	s = latinFolds.Replace(strings.ToLower(s))
+
+	var b strings.Builder
+	var prev rune
+	for _, r := range s {
+		if r == prev && unicode.IsLetter(r) {
+			continue
+		}
+		b.WriteRune(r)
+		prev = r
+	}
+	return b.String()
+}
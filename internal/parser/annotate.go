@@ -0,0 +1,318 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/xuri/excelize/v2"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	SUMMARY_SHEET_NAME = "Ошибки"
+	ANNOTATION_AUTHOR  = "Регистрация"
+
+	ANNOTATION_FILL_COLOR = "#FFC7CE"
+)
+
+// cellAnnotation - все ошибки одной ячейки. Если column пуст, ошибка относится ко всей строке.
+type cellAnnotation struct {
+	row      int
+	column   string
+	messages []string
+}
+
+// Annotate возвращает копию присланной заявки, в которой ячейки с ошибками залиты цветом и снабжены примечаниями,
+// а на отдельном листе собран список всех ошибок со ссылками на ячейки. sheet - лист, из которого прочитана заявка
+// (Response.Sheet). Книги xls и ods, а также csv excelize не записывает, поэтому для них лист с заявкой переносится
+// в новую книгу xlsx.
+func (i Impl) Annotate(data []byte, filename, sheet string, rowErrs []RowError) ([]byte, error) {
+	limits := i.Limits.withDefaults()
+
+	format, err := detectFormat(data, filename)
+	if err != nil {
+		return nil, fmt.Errorf("detectFormat failed: %w", err)
+	}
+
+	var f *excelize.File
+	if format == FORMAT_XLSX {
+		wb, err := openXlsx(data, limits)
+		if err != nil {
+			return nil, err
+		}
+		f = wb.f
+	} else {
+		rows, err := foreignRows(format, data, limits, sheet)
+		if err != nil {
+			return nil, err
+		}
+		if f, err = xlsxOf(sheet, rows); err != nil {
+			return nil, err
+		}
+		// Длинное имя листа excelize обрезает
+		sheet = f.GetSheetName(0)
+	}
+	defer f.Close()
+
+	if f.GetSheetIndex(sheet) < 0 {
+		return nil, fmt.Errorf("sheet %q: %w", sheet, ErrSheetNotFound)
+	}
+
+	annotations := annotationsOf(rowErrs)
+	if err := highlightCells(f, sheet, annotations); err != nil {
+		return nil, err
+	}
+	if err := summarySheet(f, sheet, rowErrs); err != nil {
+		return nil, err
+	}
+
+	f.SetActiveSheet(f.GetSheetIndex(sheet))
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return nil, fmt.Errorf("f.WriteToBuffer failed: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// AnnotatedFilename - имя файла заявки с отмеченными ошибками. Заявка всегда возвращается в формате xlsx.
+func AnnotatedFilename(filename string) string {
+	base := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	if base == "" || base == "." {
+		base = "Заявка"
+	}
+	return fmt.Sprintf("Ошибки - %s.xlsx", base)
+}
+
+// foreignRows читает лист заявки из файла, который excelize открыть не может.
+func foreignRows(format string, data []byte, l Limits, sheet string) ([][]string, error) {
+	var wb workbook
+	switch format {
+	case FORMAT_XLS:
+		xls, err := readXls(data, l)
+		if err != nil {
+			return nil, err
+		}
+		wb = xls
+	case FORMAT_ODS:
+		ods, err := readOds(data, l)
+		if err != nil {
+			return nil, err
+		}
+		wb = ods
+	default:
+		rows, err := readCsv(data, l)
+		if err != nil {
+			return nil, err
+		}
+		wb = memWorkbook{{sheetInfo: sheetInfo{name: CSV_SHEET_NAME}, rows: rows}}
+	}
+
+	rows, err := wb.rows(sheet, 0)
+	if err != nil {
+		return nil, fmt.Errorf("wb.rows failed: %w", err)
+	}
+	return rows, nil
+}
+
+// xlsxOf переносит строки листа в новую книгу. Пустые строки сохраняются, чтобы номера строк в RowError
+// совпадали с номерами строк в новой книге.
+func xlsxOf(sheet string, rows [][]string) (*excelize.File, error) {
+	f := excelize.NewFile()
+	f.SetSheetName(f.GetSheetName(0), sheet)
+	name := f.GetSheetName(0)
+
+	for i, row := range rows {
+		if len(row) == 0 {
+			continue
+		}
+		values := make([]interface{}, len(row))
+		for j, v := range row {
+			values[j] = v
+		}
+		if err := f.SetSheetRow(name, fmt.Sprintf("A%d", i+1), &values); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("f.SetSheetRow failed: %w", err)
+		}
+	}
+
+	return f, nil
+}
+
+// annotationsOf группирует ошибки по ячейкам: у одной ячейки может быть несколько ошибок, а примечание
+// у ячейки только одно.
+func annotationsOf(rowErrs []RowError) []cellAnnotation {
+	index := make(map[string]int, len(rowErrs))
+	annotations := make([]cellAnnotation, 0, len(rowErrs))
+	for _, e := range rowErrs {
+		if e.Row <= 0 {
+			continue
+		}
+		key := fmt.Sprintf("%s%d", e.Column, e.Row)
+		i, ok := index[key]
+		if !ok {
+			i = len(annotations)
+			index[key] = i
+			annotations = append(annotations, cellAnnotation{row: e.Row, column: e.Column})
+		}
+		annotations[i].messages = append(annotations[i].messages, e.Message)
+	}
+	return annotations
+}
+
+// highlightCells заливает ячейки с ошибками и добавляет к ним примечания. Ошибка без столбца отмечается заливкой
+// всей строки и примечанием в столбце A. Формат чисел ячейки сохраняется, иначе даты превратились бы в числа.
+func highlightCells(f *excelize.File, sheet string, annotations []cellAnnotation) error {
+	styles := make(map[int]int)
+	highlight := func(cell string) error {
+		orig, err := f.GetCellStyle(sheet, cell)
+		if err != nil {
+			return fmt.Errorf("f.GetCellStyle failed: %w", err)
+		}
+		style, ok := styles[orig]
+		if !ok {
+			if style, err = highlightedStyle(f, orig); err != nil {
+				return err
+			}
+			styles[orig] = style
+		}
+		if err := f.SetCellStyle(sheet, cell, cell, style); err != nil {
+			return fmt.Errorf("f.SetCellStyle failed: %w", err)
+		}
+		return nil
+	}
+
+	commented := make(map[string]bool)
+	for _, c := range f.GetComments()[sheet] {
+		commented[c.Ref] = true
+	}
+
+	// Ширина строк нужна только для ошибок без столбца, лист читается один раз и до изменения стилей
+	var rows [][]string
+	for _, a := range annotations {
+		column := a.column
+		if column == "" {
+			column = "A"
+			if rows == nil {
+				var err error
+				if rows, err = f.GetRows(sheet); err != nil {
+					return fmt.Errorf("f.GetRows failed: %w", err)
+				}
+			}
+			last := 1
+			if a.row <= len(rows) && len(rows[a.row-1]) > last {
+				last = len(rows[a.row-1])
+			}
+			for col := 1; col <= last; col++ {
+				cell, err := excelize.CoordinatesToCellName(col, a.row)
+				if err != nil {
+					return fmt.Errorf("excelize.CoordinatesToCellName failed: %w", err)
+				}
+				if err := highlight(cell); err != nil {
+					return err
+				}
+			}
+		}
+
+		cell := fmt.Sprintf("%s%d", column, a.row)
+		if a.column != "" {
+			if err := highlight(cell); err != nil {
+				return err
+			}
+		}
+		// excelize 2.6 не умеет удалять примечания, а второе примечание у той же ячейки Excel считает повреждением
+		// книги. Такое бывает, когда тренер присылает заявку с отмеченными ошибками повторно: ошибка всё равно
+		// останется в списке на листе SUMMARY_SHEET_NAME.
+		if commented[cell] {
+			continue
+		}
+		if err := addAnnotation(f, sheet, cell, strings.Join(a.messages, "\n")); err != nil {
+			return err
+		}
+		commented[cell] = true
+	}
+	return nil
+}
+
+// highlightedStyle создаёт стиль с заливкой и тем же форматом чисел, что у стиля orig.
+func highlightedStyle(f *excelize.File, orig int) (int, error) {
+	style := &excelize.Style{
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{ANNOTATION_FILL_COLOR}},
+	}
+
+	if f.Styles != nil && f.Styles.CellXfs != nil && orig >= 0 && orig < len(f.Styles.CellXfs.Xf) {
+		if id := f.Styles.CellXfs.Xf[orig].NumFmtID; id != nil && *id != 0 {
+			style.NumFmt = *id
+			if f.Styles.NumFmts != nil {
+				for _, nf := range f.Styles.NumFmts.NumFmt {
+					if nf.NumFmtID == *id {
+						code := nf.FormatCode
+						style.CustomNumFmt = &code
+						break
+					}
+				}
+			}
+		}
+	}
+
+	id, err := f.NewStyle(style)
+	if err != nil {
+		return 0, fmt.Errorf("f.NewStyle failed: %w", err)
+	}
+	return id, nil
+}
+
+func addAnnotation(f *excelize.File, sheet, cell, text string) error {
+	format, err := json.Marshal(map[string]string{"author": ANNOTATION_AUTHOR + ": ", "text": text})
+	if err != nil {
+		return fmt.Errorf("json.Marshal failed: %w", err)
+	}
+	if err := f.AddComment(sheet, cell, string(format)); err != nil {
+		return fmt.Errorf("f.AddComment failed: %w", err)
+	}
+	return nil
+}
+
+// summarySheet записывает список всех ошибок со ссылками на ячейки. Лист от предыдущей проверки, если тренер
+// прислал заявку с отмеченными ошибками повторно, заменяется.
+func summarySheet(f *excelize.File, sheet string, rowErrs []RowError) error {
+	if f.GetSheetIndex(SUMMARY_SHEET_NAME) >= 0 {
+		f.DeleteSheet(SUMMARY_SHEET_NAME)
+	}
+	f.NewSheet(SUMMARY_SHEET_NAME)
+
+	sorted := make([]RowError, len(rowErrs))
+	copy(sorted, rowErrs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Row < sorted[j].Row
+	})
+
+	header := []interface{}{"Строка", "Столбец", "Значение", "Ошибка"}
+	if err := f.SetSheetRow(SUMMARY_SHEET_NAME, "A1", &header); err != nil {
+		return fmt.Errorf("f.SetSheetRow failed: %w", err)
+	}
+	for i, e := range sorted {
+		row := []interface{}{e.Row, e.Column, e.Value, e.Message}
+		if err := f.SetSheetRow(SUMMARY_SHEET_NAME, fmt.Sprintf("A%d", i+2), &row); err != nil {
+			return fmt.Errorf("f.SetSheetRow failed: %w", err)
+		}
+		if e.Row <= 0 {
+			continue
+		}
+
+		column := e.Column
+		if column == "" {
+			column = "A"
+		}
+		link := fmt.Sprintf("'%s'!%s%d", strings.ReplaceAll(sheet, "'", "''"), column, e.Row)
+		if err := f.SetCellHyperLink(SUMMARY_SHEET_NAME, fmt.Sprintf("A%d", i+2), link, "Location"); err != nil {
+			return fmt.Errorf("f.SetCellHyperLink failed: %w", err)
+		}
+	}
+
+	if err := f.SetColWidth(SUMMARY_SHEET_NAME, "D", "D", 100); err != nil {
+		return fmt.Errorf("f.SetColWidth failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package parser
+
+import (
+	"bytes"
+	"github.com/xuri/excelize/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnnotate(t *testing.T) {
+	defer func(orig func() time.Time) { now = orig }(now)
+	now = func() time.Time { return testNow }
+
+	data := buildXlsx(t, xlsxFixture{rows: [][]interface{}{
+		{"UUID", testUUID},
+		{"ФИО", "Пол", "Дата рождения", "Кю", "Ката/Кумите"},
+		{"Иванов Иван", "м", time.Date(2011, time.February, 28, 0, 0, 0, 0, time.UTC), 11, "кат"},
+		{"Петрова Анна", "ж", "15.03.2011", 8, "кат"},
+	}})
+	rowErrs := []RowError{
+		{Row: 3, Column: "C", Code: ERR_CODE_BAD_BIRTH_DATE, Message: "Первая ошибка"},
+		{Row: 3, Column: "C", Code: ERR_CODE_BAD_BIRTH_DATE, Message: "Вторая ошибка"},
+		{Row: 4, Code: ERR_CODE_DUPLICATE, Message: "Участник уже зарегистрирован"},
+	}
+
+	annotated, err := Impl{}.Annotate(data, "заявка.xlsx", SHEET_NAME, rowErrs)
+	if err != nil {
+		t.Fatalf("Annotate failed: %v", err)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(annotated))
+	if err != nil {
+		t.Fatalf("excelize.OpenReader failed: %v", err)
+	}
+	defer f.Close()
+
+	comments := f.GetComments()[SHEET_NAME]
+	if len(comments) != 2 {
+		t.Fatalf("comments = %+v, want 2", comments)
+	}
+	for _, c := range comments {
+		if c.Ref == "C3" && !(strings.Contains(c.Text, "Первая ошибка") && strings.Contains(c.Text, "Вторая ошибка")) {
+			t.Errorf("comment on C3 = %q, want both errors", c.Text)
+		}
+	}
+
+	// Дата рождения должна остаться датой, а не превратиться в число
+	value, err := f.GetCellValue(SHEET_NAME, "C3")
+	if err != nil {
+		t.Fatalf("f.GetCellValue failed: %v", err)
+	}
+	if value != "02-28-11" {
+		t.Errorf("C3 = %q, want date format to be kept", value)
+	}
+
+	for _, cell := range []string{"C3", "A4", "E4"} {
+		style, err := f.GetCellStyle(SHEET_NAME, cell)
+		if err != nil {
+			t.Fatalf("f.GetCellStyle failed: %v", err)
+		}
+		if fill := f.Styles.CellXfs.Xf[style].FillID; fill == nil || *fill == 0 {
+			t.Errorf("%s is not highlighted", cell)
+		}
+	}
+
+	summary, err := f.GetRows(SUMMARY_SHEET_NAME)
+	if err != nil {
+		t.Fatalf("f.GetRows failed: %v", err)
+	}
+	if len(summary) != len(rowErrs)+1 {
+		t.Fatalf("summary = %v, want %d errors", summary, len(rowErrs))
+	}
+
+	// Заявку с отмеченными ошибками тренер присылает повторно: она по-прежнему разбирается, а отметки не дублируются
+	if _, err := (Impl{}).ParseXlsx(bytes.NewReader(annotated)); err != nil {
+		t.Fatalf("ParseXlsx of annotated file failed: %v", err)
+	}
+	again, err := Impl{}.Annotate(annotated, "заявка.xlsx", SHEET_NAME, rowErrs)
+	if err != nil {
+		t.Fatalf("Annotate of annotated file failed: %v", err)
+	}
+	f2, err := excelize.OpenReader(bytes.NewReader(again))
+	if err != nil {
+		t.Fatalf("excelize.OpenReader failed: %v", err)
+	}
+	defer f2.Close()
+	if n := len(f2.GetComments()[SHEET_NAME]); n != 2 {
+		t.Errorf("%d comments after second annotation, want 2", n)
+	}
+	if rows, _ := f2.GetRows(SUMMARY_SHEET_NAME); len(rows) != len(rowErrs)+1 {
+		t.Errorf("summary after second annotation = %v", rows)
+	}
+}
+
+func TestAnnotateCsv(t *testing.T) {
+	data := []byte("UUID;" + testUUID + "\nФИО;Пол;Возраст;Кю;Ката/Кумите\nИванов Иван;ч;12;8;кат\n")
+
+	annotated, err := Impl{}.Annotate(data, "заявка.csv", CSV_SHEET_NAME,
+		[]RowError{{Row: 3, Column: "B", Code: ERR_CODE_BAD_SEX, Message: "Пол"}})
+	if err != nil {
+		t.Fatalf("Annotate failed: %v", err)
+	}
+	f, err := excelize.OpenReader(bytes.NewReader(annotated))
+	if err != nil {
+		t.Fatalf("excelize.OpenReader failed: %v", err)
+	}
+	defer f.Close()
+
+	if value, _ := f.GetCellValue(CSV_SHEET_NAME, "B3"); value != "ч" {
+		t.Errorf("B3 = %q", value)
+	}
+	if comments := f.GetComments()[CSV_SHEET_NAME]; len(comments) != 1 || comments[0].Ref != "B3" {
+		t.Errorf("comments = %+v", comments)
+	}
+	if name := AnnotatedFilename("заявка.csv"); name != "Ошибки - заявка.xlsx" {
+		t.Errorf("AnnotatedFilename = %q", name)
+	}
+}
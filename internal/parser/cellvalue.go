@@ -0,0 +1,207 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Geniuskaa/micro_registration/internal/sports/karate"
+	"github.com/jackc/pgtype"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Допустимые значения числовых полей участника
+const (
+	MIN_WEIGHT = 11 // Вес в БД должен быть больше 10 кг
+	MAX_WEIGHT = 250
+	MAX_AGE    = 100
+	MAX_GRADE  = 10 // Кю и дан
+
+	FLOAT_TOLERANCE = 1e-9
+)
+
+// Допустимые единицы измерения после числа в ячейке ("45,5 кг", "3 кю", "12 лет"). Сравниваются без учёта
+// регистра и точки в конце.
+var (
+	unitsWeight = []string{"кг", "kg", "килограмм", "килограммов"}
+	unitsAge    = []string{"лет", "год", "года"}
+	unitsKyi    = []string{"кю", "kyu"}
+	unitsDan    = []string{"дан", "dan"}
+)
+
+var (
+	errEmptyValue = errors.New("значение не указано")
+
+	// Число с точкой или запятой и необязательная приписка после него
+	numberRegex = regexp.MustCompile(`^([+-]?[0-9]+(?:[.,][0-9]+)?)\s*(.*)$`)
+
+	// Варианты написания пола -> значение karate_category.sex (после normalizeHeader)
+	sexSynonyms = func() map[string]string {
+		spellings := map[string][]string{
+			karate.SEX_MALE:   {"м", "муж", "мужской", "мужчина", "мальчик", "юноша", "m", "male", "man", "boy"},
+			karate.SEX_FEMALE: {"ж", "жен", "женский", "женщина", "девочка", "девушка", "f", "female", "woman", "girl"},
+		}
+
+		m := make(map[string]string, 20)
+		for sex, list := range spellings {
+			for _, s := range list {
+				m[normalizeHeader(s)] = sex
+			}
+		}
+		return m
+	}()
+
+	// Записи весовой категории: "-45", "до 45" и просто "45" - верхняя граница; "45-50", "от 45 до 50" - обе;
+	// "70+", "свыше 70" - только нижняя
+	categoryUpperRegex = regexp.MustCompile(`^(?:-|до|<=?|≤)?\s*([0-9]{1,3})$`)
+	categoryRangeRegex = regexp.MustCompile(`^(?:от\s*)?([0-9]{1,3})\s*(?:-|до)\s*([0-9]{1,3})$`)
+	categoryLowerRegex = regexp.MustCompile(`^(?:(?:\+|свыше|св\.?|более|больше|от|>=?|≥)\s*([0-9]{1,3})|([0-9]{1,3})\s*\+)$`)
+	categoryDashes     = strings.NewReplacer("–", "-", "—", "-", "−", "-")
+
+	romanNumerals = map[string]int{
+		"I": 1, "II": 2, "III": 3, "IV": 4, "V": 5, "VI": 6, "VII": 7, "VIII": 8, "IX": 9, "X": 10,
+	}
+	// Кириллические буквы, которые при наборе римских цифр путают с латинскими
+	romanLookalikes = strings.NewReplacer("Х", "X", "х", "X", "І", "I", "і", "I", "Ι", "I")
+)
+
+// parseCellFloat читает число из ячейки: десятичная запятая или точка, пробелы (в т.ч. неразрывные) и одна из
+// единиц измерения units после числа допускаются. Ошибка описывает, что именно не удалось понять.
+func parseCellFloat(s string, units []string) (float64, error) {
+	s = strings.TrimSpace(strings.ReplaceAll(s, "\u00a0", " "))
+	if s == "" {
+		return 0, errEmptyValue
+	}
+
+	match := numberRegex.FindStringSubmatch(s)
+	if match == nil {
+		return 0, fmt.Errorf("%q не является числом", s)
+	}
+
+	if unit := match[2]; unit != "" && !isUnit(unit, units) {
+		return 0, fmt.Errorf("непонятная приписка %q после числа", unit)
+	}
+
+	v, err := strconv.ParseFloat(strings.Replace(match[1], ",", ".", 1), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("%q не является числом", match[1])
+	}
+
+	return v, nil
+}
+
+// parseCellInt читает целое число. Числа из Excel часто приходят в виде "12.0", они допускаются, а "12.5" - нет.
+func parseCellInt(s string, units []string) (int, error) {
+	v, err := parseCellFloat(s, units)
+	if err != nil {
+		return 0, err
+	}
+
+	// Результат формулы в xlsx бывает записан с погрешностью: "12.000000000000002"
+	if math.Abs(v-math.Round(v)) > FLOAT_TOLERANCE {
+		return 0, fmt.Errorf("%s - дробное число, а ожидается целое", strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	if v > math.MaxInt32 || v < math.MinInt32 {
+		return 0, fmt.Errorf("%s - слишком большое число", strconv.FormatFloat(v, 'f', -1, 64))
+	}
+
+	return int(math.Round(v)), nil
+}
+
+// parseCellGrade читает кю или дан: арабскими или римскими (I - X) цифрами, с припиской units или без.
+func parseCellGrade(s string, units []string) (int, error) {
+	v, err := parseCellInt(s, units)
+	if err == nil || errors.Is(err, errEmptyValue) {
+		return v, err
+	}
+
+	word, unit := splitUnit(strings.TrimSpace(s))
+	if unit != "" && !isUnit(unit, units) {
+		return 0, err
+	}
+	if n, ok := romanNumerals[romanLookalikes.Replace(strings.ToUpper(word))]; ok {
+		return n, nil
+	}
+
+	return 0, err
+}
+
+// parseSex приводит пол к значению karate.SEX_MALE или karate.SEX_FEMALE. Регистр, точки и пробелы
+// не учитываются ("М", "муж.", "Male").
+func parseSex(s string) (string, error) {
+	if strings.TrimSpace(s) == "" {
+		return "", errEmptyValue
+	}
+
+	sex, ok := sexSynonyms[normalizeHeader(s)]
+	if !ok {
+		return "", fmt.Errorf("%q не является обозначением пола", strings.TrimSpace(s))
+	}
+	return sex, nil
+}
+
+// parseWeightCategory разбирает запись весовой категории в диапазон в той же форме, в которой Postgres
+// возвращает karate_category.weight: "45-50" -> [45,51), "-45" -> (,46), "70+" -> [70,).
+func parseWeightCategory(s string) (pgtype.Int4range, error) {
+	r := pgtype.Int4range{}
+	v := strings.ToLower(strings.TrimSpace(strings.ReplaceAll(s, "\u00a0", " ")))
+	if v == "" {
+		return r, errEmptyValue
+	}
+
+	v = strings.TrimSpace(strings.TrimRight(categoryDashes.Replace(v), "."))
+	for _, u := range []string{"кг", "kg"} {
+		v = strings.TrimSpace(strings.TrimSuffix(v, u))
+	}
+
+	var bounds string
+	if m := categoryRangeRegex.FindStringSubmatch(v); m != nil {
+		lower, _ := strconv.Atoi(m[1])
+		upper, _ := strconv.Atoi(m[2])
+		if lower >= upper {
+			return r, fmt.Errorf("в %q нижняя граница не меньше верхней", strings.TrimSpace(s))
+		}
+		bounds = fmt.Sprintf("[%d,%d)", lower, upper+1)
+	} else if m := categoryLowerRegex.FindStringSubmatch(v); m != nil {
+		bounds = fmt.Sprintf("[%s%s,)", m[1], m[2])
+	} else if m := categoryUpperRegex.FindStringSubmatch(v); m != nil {
+		upper, _ := strconv.Atoi(m[1])
+		bounds = fmt.Sprintf("(,%d)", upper+1)
+	} else {
+		return r, fmt.Errorf("%q не похоже на весовую категорию", strings.TrimSpace(s))
+	}
+
+	if err := r.Set(bounds); err != nil {
+		return r, fmt.Errorf("r.Set failed: %w", err)
+	}
+	return r, nil
+}
+
+// checkRange проверяет, что значение лежит в [min, max].
+func checkRange(v, min, max float64) error {
+	if v < min || v > max {
+		return fmt.Errorf("%s - вне допустимого диапазона от %s до %s", strconv.FormatFloat(v, 'f', -1, 64),
+			strconv.FormatFloat(min, 'f', -1, 64), strconv.FormatFloat(max, 'f', -1, 64))
+	}
+	return nil
+}
+
+// splitUnit делит "III кю" на "III" и "кю".
+func splitUnit(s string) (string, string) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return s, ""
+	}
+	return fields[0], strings.Join(fields[1:], " ")
+}
+
+func isUnit(s string, units []string) bool {
+	s = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), ".")
+	for _, u := range units {
+		if s == u {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xuri/excelize/v2"
+	"strings"
+	"unicode"
+)
+
+// Ключи столбцов заявки по каратэ. Порядок столбцов в файле может быть любым, он определяется по строке заголовков.
+const (
+	COL_FULLNAME    = "fullname"
+	COL_SEX         = "sex"
+	COL_AGE         = "age"
+	COL_BIRTH_DATE  = "birth_date"
+	COL_KYI         = "kyi"
+	COL_DAN         = "dan"
+	COL_CITY        = "city"
+	COL_KATA_KUMITE = "kata_kumite"
+	COL_KATA_GROUP  = "kata_group"
+	COL_KATA_TEAM   = "kata_team"
+	COL_WEIGHT      = "weight"
+	COL_CATEGORY    = "category"
+	COL_COACH       = "coach"
+
+	// Строку заголовков ищем только среди первых строк листа, ниже неё начинаются участники.
+	MAX_HEADER_SEARCH_ROWS = 20
+	// Минимальное кол-во распознанных заголовков, чтобы строка считалась строкой заголовков.
+	MIN_HEADER_MATCHES = 3
+)
+
+var (
+	ErrHeaderNotFound = errors.New("Не найдена строка с заголовками столбцов (ФИО, Пол, Возраст, ...)")
+)
+
+// MissingColumnsError возвращается, если в строке заголовков нет обязательных столбцов.
+type MissingColumnsError struct {
+	Missing []string
+}
+
+func (e *MissingColumnsError) Error() string {
+	return fmt.Sprintf("В заявке не найдены обязательные столбцы: %s", strings.Join(e.Missing, ", "))
+}
+
+type columnInfo struct {
+	key      string
+	title    string   // Название столбца в шаблоне заявки, им же подписываем ошибки
+	synonyms []string // Допустимые варианты написания заголовка
+	required bool
+	alt      string // Столбец, который может заменить обязательный столбец
+}
+
+var karateColumns = []columnInfo{
+	{COL_FULLNAME, "ФИО", []string{"ФИО", "Ф.И.О.", "Фамилия Имя Отчество", "Фамилия, имя, отчество", "Участник", "Спортсмен"}, true, ""},
+	{COL_SEX, "Пол", []string{"Пол"}, true, ""},
+	{COL_AGE, "Возраст", []string{"Возраст", "Полных лет", "Лет"}, true, COL_BIRTH_DATE},
+	{COL_BIRTH_DATE, "Дата рождения", []string{"Дата рождения", "Д.р.", "Дата рожд.", "Рожд."}, false, ""},
+	{COL_KYI, "Кю", []string{"Кю", "Kyu", "Пояс", "Кю (пояс)"}, true, ""},
+	{COL_DAN, "Дан", []string{"Дан", "Dan"}, false, ""},
+	{COL_CITY, "Город", []string{"Город", "Населенный пункт"}, false, ""},
+	{COL_KATA_KUMITE, "Ката/Кумите", []string{"Ката/Кумите", "Кат/Кум", "Дисциплина", "Вид программы"}, true, ""},
+	{COL_KATA_GROUP, "Ката группа", []string{"Ката группа", "Групповое ката", "Командное ката", "Ката-группа"}, false, ""},
+	{COL_KATA_TEAM, "Команда ката", []string{"Команда ката", "Ката команда", "Команда (ката)", "Название команды"}, false, ""},
+	{COL_WEIGHT, "Вес", []string{"Вес", "Вес, кг", "Вес (кг)"}, false, ""},
+	{COL_CATEGORY, "Категория", []string{"Категория", "Весовая категория"}, false, ""},
+	{COL_COACH, "Тренер", []string{"Тренер", "ФИО тренера", "Тренер (ФИО)"}, false, ""},
+}
+
+// columnTitle подписывает столбец в сообщении об ошибке вместе со столбцом, который может его заменить.
+func columnTitle(c columnInfo) string {
+	for _, alt := range karateColumns {
+		if c.alt != "" && alt.key == c.alt {
+			return c.title + " или " + alt.title
+		}
+	}
+	return c.title
+}
+
+// headerSynonyms - нормализованный заголовок -> ключ столбца
+var headerSynonyms = func() map[string]string {
+	m := make(map[string]string, 40)
+	for _, c := range karateColumns {
+		for _, s := range c.synonyms {
+			m[normalizeHeader(s)] = c.key
+		}
+	}
+	return m
+}()
+
+// columnMap хранит индекс столбца в строке для каждого распознанного ключа.
+type columnMap map[string]int
+
+// index возвращает индекс столбца или -1, если такого столбца в файле нет.
+func (c columnMap) index(key string) int {
+	idx, ok := c[key]
+	if !ok {
+		return -1
+	}
+	return idx
+}
+
+// cell возвращает значение ячейки строки по ключу столбца. Для отсутствующих столбцов и коротких строк вернёт "".
+func (c columnMap) cell(row []string, key string) string {
+	idx := c.index(key)
+	if idx < 0 || idx >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[idx])
+}
+
+// letters возвращает буквы столбцов в нумерации Excel, чтобы по ним можно было подписать ошибки, найденные уже
+// после разбора файла.
+func (c columnMap) letters() map[string]string {
+	m := make(map[string]string, len(c))
+	for key, idx := range c {
+		name, err := excelize.ColumnNumberToName(idx + 1)
+		if err != nil {
+			continue
+		}
+		m[key] = name
+	}
+	return m
+}
+
+// normalizeHeader приводит заголовок к виду для сравнения: нижний регистр, ё -> е, остаются только буквы, цифры и "/".
+func normalizeHeader(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if r == 'ё' {
+			r = 'е'
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '/' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// mapHeaderRow распознаёт заголовки строки. Повторный заголовок не перезаписывает первый найденный.
+func mapHeaderRow(row []string) columnMap {
+	cols := make(columnMap, len(karateColumns))
+	for i, v := range row {
+		key, ok := headerSynonyms[normalizeHeader(v)]
+		if !ok {
+			continue
+		}
+		if _, exists := cols[key]; !exists {
+			cols[key] = i
+		}
+	}
+	return cols
+}
+
+// findHeader ищет строку заголовков среди первых MAX_HEADER_SEARCH_ROWS строк и строит по ней карту столбцов.
+// Возвращает индекс строки заголовков в rows.
+func findHeader(rows [][]string) (int, columnMap, error) {
+	for i, row := range rows {
+		if i >= MAX_HEADER_SEARCH_ROWS {
+			break
+		}
+
+		cols := mapHeaderRow(row)
+		if len(cols) < MIN_HEADER_MATCHES {
+			continue
+		}
+
+		missing := make([]string, 0)
+		for _, c := range karateColumns {
+			if _, ok := cols[c.key]; !c.required || ok {
+				continue
+			}
+			if _, ok := cols[c.alt]; c.alt != "" && ok {
+				continue
+			}
+			missing = append(missing, columnTitle(c))
+		}
+		if len(missing) != 0 {
+			return i, nil, &MissingColumnsError{Missing: missing}
+		}
+
+		return i, cols, nil
+	}
+
+	return 0, nil, ErrHeaderNotFound
+}
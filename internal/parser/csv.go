@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"golang.org/x/text/encoding/charmap"
+	"io"
+	"unicode/utf8"
+)
+
+const (
+	// Кол-во первых строк файла, по которым определяется разделитель
+	CSV_SAMPLE_LINES = 5
+	// В CSV нет листов, единственный лист называем так
+	CSV_SHEET_NAME = "CSV"
+)
+
+var (
+	utf8BOM       = []byte("\xEF\xBB\xBF")
+	csvDelimiters = []rune{';', ',', '\t'}
+)
+
+// readCsv читает CSV-выгрузку таблицы. Русский Excel сохраняет CSV в windows-1251 с разделителем ";",
+// Google Sheets - в UTF-8 с ",", поэтому кодировка и разделитель определяются по содержимому.
+func readCsv(data []byte, l Limits) ([][]string, error) {
+	data = bytes.TrimPrefix(data, utf8BOM)
+
+	if !utf8.Valid(data) {
+		decoded, err := charmap.Windows1251.NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("charmap.Windows1251 decoding failed: %w", err)
+		}
+		data = decoded
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = detectCsvDelimiter(data)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	rl := newRowLimiter(l)
+	rows := make([][]string, 0, 64)
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("csv.Read failed: %w", err)
+		}
+
+		// csv.Reader пропускает пустые строки и склеивает многострочные значения в кавычках, поэтому номер
+		// строки берётся по началу записи в файле: по нему тренер найдёт ошибку в заявке
+		line, _ := r.FieldPos(0)
+		if err := rl.check(line, row); err != nil {
+			return nil, err
+		}
+		for len(rows) < line-1 {
+			rows = append(rows, nil)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// detectCsvDelimiter выбирает разделитель, который чаще других встречается в первых строках файла.
+func detectCsvDelimiter(data []byte) rune {
+	lines := bytes.SplitN(data, []byte("\n"), CSV_SAMPLE_LINES+1)
+	if len(lines) > CSV_SAMPLE_LINES {
+		lines = lines[:CSV_SAMPLE_LINES]
+	}
+	sample := bytes.Join(lines, []byte("\n"))
+
+	best, bestCount := csvDelimiters[0], 0
+	for _, d := range csvDelimiters {
+		if c := bytes.Count(sample, []byte(string(d))); c > bestCount {
+			best, bestCount = d, c
+		}
+	}
+
+	return best
+}
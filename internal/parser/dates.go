@@ -0,0 +1,44 @@
+package parser
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Excel хранит дату как число дней от 30.12.1899. Меньшие числа - это скорее год или возраст, чем дата.
+	EXCEL_MIN_DATE_SERIAL = 367     // 01.01.1901
+	EXCEL_MAX_DATE_SERIAL = 2958465 // 31.12.9999
+)
+
+var (
+	excelEpoch = time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)
+
+	dateLayouts = []string{"02.01.2006", "2.1.2006", "02.01.06", "2.1.06", "2006-01-02", "02/01/2006", "2/1/2006",
+		"02-01-2006", "2006-01-02T15:04:05", "02.01.2006 15:04:05", "02.01.2006 15:04"}
+)
+
+// parseDate распознаёт дату, записанную текстом в одном из dateLayouts или числом дней Excel: так приходят
+// ячейки с форматом даты из xlsx и xls.
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+
+	return parseExcelSerial(s)
+}
+
+func parseExcelSerial(s string) (time.Time, bool) {
+	serial, err := strconv.ParseFloat(s, 64)
+	if err != nil || serial < EXCEL_MIN_DATE_SERIAL || serial > EXCEL_MAX_DATE_SERIAL {
+		return time.Time{}, false
+	}
+
+	// Время суток в дате рождения не нужно, отбрасываем дробную часть
+	return excelEpoch.AddDate(0, 0, int(math.Floor(serial))), true
+}
@@ -1,21 +1,23 @@
 package parser
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"github.com/Geniuskaa/micro_registration/internal/names"
 	"github.com/Geniuskaa/micro_registration/internal/sports/karate"
 	"github.com/jackc/pgtype"
 	"github.com/xuri/excelize/v2"
 	"io"
-	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	SHEET_NAME             = "Лист 1"
-	COUNT_OF_METAINFO_ROWS = 6
+	SHEET_NAME = "Лист 1"
 
-	// Constants for parser protection
+	// Constants for parser protection, значения по умолчанию для Limits
+	MAX_COUNT_OF_ROWS                      = 1500
 	MAX_LEN_OF_ROW                         = 15 // Длина строки измеряется в кол-ве ячеек excel таблицы
 	COUNTS_OF_LONG_ROWS_BEFORE_BLOCK_EXCEL = 10
 
@@ -26,66 +28,190 @@ const (
 	KARATE = "KARATE"
 )
 
+// now - текущее время, по нему считается возраст по дате рождения. В тестах подменяется на фиксированную дату.
+var now = time.Now
+
 type Impl struct {
+	SheetName string // Имя листа с заявкой. Если пусто - SHEET_NAME. Если листа нет, лист ищется по заголовкам.
+	Limits    Limits // Ограничения на размер и содержимое файла, незаполненные поля берутся из DefaultLimits
+
+	Transliterate bool // Заполнять ФИО латиницей (karate.Participant.FullNameLatin)
 }
 
 type Response struct {
-	SportType   string
-	PercentErrs int
-	UUID        string
-	Map         map[string]interface{}
+	SportType   string                 `json:"sport_type"`
+	PercentErrs float64                `json:"percent_errs"` // Доля строк участников с ошибками, в процентах
+	TotalRows   int                    `json:"total_rows"`   // Кол-во непустых строк участников
+	InvalidRows int                    `json:"invalid_rows"` // Из них строк с ошибками
+	UUID        string                 `json:"uuid"`
+	Map         map[string]interface{} `json:"participants"`
+	RowErrors   []RowError             `json:"row_errors"`
+	Meta        Metadata               `json:"meta"`
+	Sheet       string                 `json:"sheet"`   // Лист, из которого прочитана заявка
+	Columns     map[string]string      `json:"columns"` // Ключ столбца (COL_*) -> буква столбца на листе
 }
 
-// Парсер обрабатывает структуры и закинет все данные в мапу с ключом "ФИО"
+// Парсер обрабатывает структуры и закинет все данные в мапу с ключом karate.ParticipantKey (ФИО и возраст)
 //
 //	затем полученную мапу мы должны передать в валидатор, после получения одобрения от него отправим мапу в репозиторий
 //	По UID парсер должен понять какой это вид спорта и использовать соотвествующий парсер
 func (i Impl) ParseXlsx(r io.Reader) (*Response, error) { //(sportType string, percentErrs int, uuidVal int, m map[string]interface{}, err error)
-	resp := &Response{}
+	limits := i.Limits.withDefaults()
+
+	data, err := readLimited(r, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	wb, err := openXlsx(data, limits)
+	if err != nil {
+		return nil, err
+	}
+	defer wb.close()
+
+	return i.parseWorkbook(wb)
+}
+
+// parseWorkbook выбирает в книге лист с заявкой и разбирает его.
+func (i Impl) parseWorkbook(wb workbook) (*Response, error) {
+	preferred := i.SheetName
+	if preferred == "" {
+		preferred = SHEET_NAME
+	}
+
+	name, err := selectSheet(wb, preferred)
+	if err != nil {
+		return nil, fmt.Errorf("selectSheet failed: %w", err)
+	}
 
-	f, err := excelize.OpenReader(r)
+	rows, err := wb.rows(name, 0)
+	if err != nil {
+		return nil, fmt.Errorf("wb.rows failed: %w", err)
+	}
+
+	resp, err := i.parseRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	resp.Sheet = name
+
+	return resp, nil
+}
+
+// xlsxWorkbook читает строки листов лениво, через потоковый итератор excelize.
+type xlsxWorkbook struct {
+	f      *excelize.File
+	limits Limits
+}
+
+// openXlsx до распаковки проверяет заголовки архива на zip-бомбу, а excelize дополнительно ограничивает
+// суммарный объём распаковки.
+func openXlsx(data []byte, l Limits) (*xlsxWorkbook, error) {
+	if err := checkZip(data, l); err != nil {
+		return nil, err
+	}
+
+	f, err := excelize.OpenReader(bytes.NewReader(data), excelize.Options{
+		UnzipSizeLimit:    l.MaxUnzipSize,
+		UnzipXMLSizeLimit: l.MaxUnzipSize,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("excelize.OpenReader failed: %w", err)
 	}
 
-	defer func() {
-		if err := f.Close(); err != nil {
-		}
-	}()
+	return &xlsxWorkbook{f: f, limits: l}, nil
+}
+
+func (w *xlsxWorkbook) close() {
+	if err := w.f.Close(); err != nil {
+	}
+}
+
+func (w *xlsxWorkbook) sheets() []sheetInfo {
+	names := w.f.GetSheetList()
+	list := make([]sheetInfo, len(names))
+	for i, name := range names {
+		list[i] = sheetInfo{name: name, hidden: !w.f.GetSheetVisible(name)}
+	}
+	return list
+}
 
-	rows, err := f.GetRows(SHEET_NAME)
+// rows читает лист построчно и проверяет каждую строку сразу после чтения, поэтому на вредоносном файле парсер
+// останавливается на первой же строке сверх ограничений, не читая лист до конца. Пустые строки добавляются
+// только перед непустыми, так что пустой хвост листа в память не попадает.
+func (w *xlsxWorkbook) rows(name string, limit int) ([][]string, error) {
+	it, err := w.f.Rows(name)
 	if err != nil {
-		return nil, fmt.Errorf("f.GetRows failed: %w", err)
+		return nil, fmt.Errorf("f.Rows failed: %w", err)
 	}
+	defer it.Close()
+
+	rl := newRowLimiter(w.limits)
+	rows := make([][]string, 0, 64)
+	rowNum, pendingEmpty := 0, 0
+	for it.Next() {
+		rowNum++
+		if limit > 0 && rowNum > limit {
+			break
+		}
 
-	// Применять ниже описанную фильтрацию для файлов с кол-вом строк более 40
-	// Нужно делать замеры, если процент пустых позиций выше 20% то мы отбросим файл.
-	//if len(rows) > 40 {
-	//	if percent > 20 {
-	//		return
-	//	}
-	//}
+		// Даты нужны в виде числа дней Excel: отформатированная excelize дата ("03-15-12") неоднозначна
+		row, err := it.Columns(excelize.Options{RawCellValue: true})
+		if err != nil {
+			return nil, fmt.Errorf("rows.Columns failed: %w", err)
+		}
+		if err := rl.check(rowNum, row); err != nil {
+			return nil, err
+		}
 
-	if len(rows) > 1500 {
-		return nil, fmt.Errorf("Xlsx doc has more than 1500 rows. It seems that someone try to DDOS us...")
+		if isEmptyRow(row) {
+			pendingEmpty++
+			continue
+		}
+		for ; pendingEmpty > 0; pendingEmpty-- {
+			rows = append(rows, nil)
+		}
+		rows = append(rows, row)
 	}
 
-	//TODO: ОБЯЗАТЕЛЬНО!!! Сделать аналитику по длине строки. Если, к примеру, более 15. То это спаммер и закончить парсинг
+	return rows, nil
+}
 
-	// По UID мы найдем соревнование в БД
-	resp.UUID = rows[1][0]
+// parseRows - общая для всех форматов часть: файл уже прочитан в строки листа, дальше разбор не зависит от формата.
+func (i Impl) parseRows(rows [][]string) (*Response, error) {
+	resp := &Response{}
 
 	// TODO: обращение в REDIS и возврат вида спорта
 	sportType := KARATE
 
 	switch sportType {
 	case KARATE:
-		percentErrs, m, err := karateParser(rows)
+		headerIdx, cols, err := findHeader(rows)
+		if err != nil {
+			return nil, fmt.Errorf("findHeader failed: %w", err)
+		}
+
+		// По UID мы найдем соревнование в БД
+		meta, metaErrs, err := metadataParser(rows, headerIdx)
+		if err != nil {
+			return nil, fmt.Errorf("metadataParser failed: %w", err)
+		}
+		resp.Meta = meta
+		resp.UUID = meta.UUID
+
+		totalRows, invalidRows, m, rowErrs, err := karateParser(rows, headerIdx, cols, i.Transliterate)
 		if err != nil {
 			return nil, fmt.Errorf("karateParser failed: %w", err)
 		}
-		resp.PercentErrs = percentErrs
+		if totalRows == 0 {
+			return nil, ErrNoParticipants
+		}
+		resp.TotalRows = totalRows
+		resp.InvalidRows = invalidRows
+		resp.PercentErrs = percentOf(invalidRows, totalRows)
 		resp.Map = m
+		resp.Columns = cols.letters()
+		resp.RowErrors = append(metaErrs, rowErrs...)
 		resp.SportType = sportType
 
 		return resp, nil
@@ -95,54 +221,70 @@ func (i Impl) ParseXlsx(r io.Reader) (*Response, error) { //(sportType string, p
 
 }
 
-func karateParser(arr [][]string) (percentErrs int, m map[string]interface{}, err error) {
-	m = make(map[string]interface{}, len(arr)-2)
+// karateParser разбирает строки участников, расположенные ниже строки заголовков headerIdx. Возвращает кол-во
+// непустых строк участников и кол-во строк из них, которые не удалось распознать.
+func karateParser(arr [][]string, headerIdx int, cols columnMap, transliterate bool) (totalRows, invalidRows int, m map[string]interface{}, rowErrs []RowError, err error) {
+	m = make(map[string]interface{}, len(arr)-headerIdx)
+	seen := make(map[string][]karate.Participant) // Участники по karate.ParticipantKey, в т.ч. тёзки
+	rowErrs = make([]RowError, 0)
 	countOfErrs := 0
 	countOfEmptyRows := 0
-	countOfVeryLongRows := 0
 
 	for i, row := range arr {
-		if i < COUNT_OF_METAINFO_ROWS {
+		if i <= headerIdx {
 			continue
 		}
 
-		if row == nil || row[0] == "" || row[1] == "" || row[2] == "" || row[5] == "" {
+		if row == nil || cols.cell(row, COL_FULLNAME) == "" || cols.cell(row, COL_SEX) == "" ||
+			(cols.cell(row, COL_AGE) == "" && cols.cell(row, COL_BIRTH_DATE) == "") || cols.cell(row, COL_KATA_KUMITE) == "" {
 			countOfEmptyRows++
 			continue
 		}
 
-		// Если часто (задаётся константно) попадаются длинные строки, система сочтёт это за спам
-		if len(row) > MAX_LEN_OF_ROW {
-			countOfVeryLongRows++
-			if countOfVeryLongRows > COUNTS_OF_LONG_ROWS_BEFORE_BLOCK_EXCEL {
-				return countOfErrs, nil, errors.New("We found too much long rows. It seems that it is spam.")
-			}
+		fullName := names.Parse(cols.cell(row, COL_FULLNAME))
+		if !fullName.Complete() {
+			countOfErrs++
+			rowErrs = append(rowErrs, toRowError(i, row, newCellError(cols.index(COL_FULLNAME), ERR_CODE_BAD_FULLNAME,
+				"Укажите фамилию и имя участника, например \"Иванов Иван Иванович\"")))
+			continue
+		}
+
+		sex, sexErr := parseSex(cols.cell(row, COL_SEX))
+		if sexErr != nil {
+			countOfErrs++
+			rowErrs = append(rowErrs, toRowError(i, row, newCellError(cols.index(COL_SEX), ERR_CODE_BAD_SEX,
+				fmt.Sprintf("Пол: %s. Укажите \"м\" или \"ж\"", sexErr))))
+			continue
 		}
 
 		var (
 			err           error
-			age, kyi, dan uint8   = 6, 0, 0
+			age, kyi, dan uint8 = 6, 0, 0
+			birthDate     time.Time
 			weight        float32 = 11
 			kataGroup     bool    = false
 			cat           pgtype.Int4range
 		)
 
-		katKum := strings.Split(row[5], "/")
+		katKum := strings.Split(cols.cell(row, COL_KATA_KUMITE), "/")
 
-		val := strings.ToLower(katKum[0])
+		val := strings.ToLower(strings.TrimSpace(katKum[0]))
 		katkumSize := len(katKum)
 
 		doKata, doKumite := false, false
 
 		if katkumSize == 2 || strings.EqualFold(val, KARATE_KUMITE) {
-			if katkumSize == 2 && !(strings.EqualFold(strings.ToLower(katKum[0]), KARATE_KATA) &&
-				strings.EqualFold(strings.ToLower(katKum[1]), KARATE_KUMITE)) {
+			if katkumSize == 2 && !(strings.EqualFold(val, KARATE_KATA) &&
+				strings.EqualFold(strings.ToLower(strings.TrimSpace(katKum[1])), KARATE_KUMITE)) {
 				countOfErrs++
+				rowErrs = append(rowErrs, toRowError(i, row, newCellError(cols.index(COL_KATA_KUMITE),
+					ERR_CODE_UNKNOWN_KATA_KUMITE, "Неизвестное значение дисциплины. Укажите \"кат\", \"кум\" или \"кат/кум\"")))
 				continue
 			}
-			age, kyi, dan, cat, kataGroup, weight, err = rowKarateConverterKumite(row)
+			age, kyi, dan, birthDate, cat, kataGroup, weight, err = rowKarateConverterKumite(row, cols)
 			if err != nil {
 				countOfErrs++
+				rowErrs = append(rowErrs, rowErrorOf(i, row, err))
 				continue
 			}
 			if katkumSize == 2 {
@@ -152,128 +294,217 @@ func karateParser(arr [][]string) (percentErrs int, m map[string]interface{}, er
 				doKumite = true
 			}
 		} else if strings.EqualFold(val, KARATE_KATA) {
-			age, kyi, dan, kataGroup, err = rowKarateConverterKata(row)
+			age, kyi, dan, birthDate, kataGroup, err = rowKarateConverterKata(row, cols)
 			if err != nil {
 				countOfErrs++
+				rowErrs = append(rowErrs, rowErrorOf(i, row, err))
 				continue
 			}
 			doKata = true
 		} else {
 			countOfErrs++
+			rowErrs = append(rowErrs, toRowError(i, row, newCellError(cols.index(COL_KATA_KUMITE),
+				ERR_CODE_UNKNOWN_KATA_KUMITE, "Неизвестное значение дисциплины. Укажите \"кат\", \"кум\" или \"кат/кум\"")))
+			continue
+		}
+
+		// Название команды само по себе означает участие в групповом ката
+		team := strings.Join(strings.Fields(cols.cell(row, COL_KATA_TEAM)), " ")
+		if team != "" && !doKata {
+			countOfErrs++
+			rowErrs = append(rowErrs, toRowError(i, row, newCellError(cols.index(COL_KATA_TEAM), ERR_CODE_BAD_TEAM,
+				"Указана команда ката, но участник не заявлен на ката. Укажите \"кат\" или \"кат/кум\"")))
 			continue
 		}
 
 		entity := karate.Participant{
-			FullName:   row[0],
-			Sex:        row[1],
+			Row:        i + 1,
+			FullName:   fullName.String(),
+			Surname:    fullName.Surname,
+			FirstName:  fullName.Name,
+			Patronymic: fullName.Patronymic,
+			Sex:        sex,
 			Age:        age,
+			BirthDate:  birthDate,
 			Kyi:        kyi,
 			Dan:        dan,
-			City:       row[4],
+			City:       cols.cell(row, COL_CITY),
 			KataKumite: [2]bool{doKata, doKumite},
-			KataGroup:  kataGroup,
+			KataGroup:  kataGroup || team != "",
+			KataTeam:   team,
 			Weight:     weight,
 			Category:   cat,
-			Coach:      row[9],
+			Coach:      cols.cell(row, COL_COACH),
+		}
+
+		if transliterate {
+			entity.FullNameLatin = fullName.Latin()
 		}
 
-		m[entity.FullName] = entity
+		// Один и тот же участник, указанный в заявке дважды, не должен молча затирать первую строку
+		key := karate.ParticipantKey(entity.FullName, entity.Age)
+		if first, ok := sameParticipant(seen[key], entity); ok {
+			countOfErrs++
+			rowErrs = append(rowErrs, toRowError(i, row, newCellError(cols.index(COL_FULLNAME), ERR_CODE_DUPLICATE,
+				fmt.Sprintf("Участник уже указан в заявке в строке %d", first.Row))))
+			continue
+		}
+		seen[key] = append(seen[key], entity)
+		// У тёзок одного возраста разные даты рождения, по ним и различаем ключи в мапе
+		if len(seen[key]) > 1 {
+			key = fmt.Sprintf("%s|%s", key, entity.BirthDate.Format("2006-01-02"))
+		}
+		m[key] = entity
 	}
 
-	totalParticipants := len(arr) - COUNT_OF_METAINFO_ROWS - countOfEmptyRows
-	percentErrs = countOfErrs / totalParticipants * 100
+	totalParticipants := len(arr) - (headerIdx + 1) - countOfEmptyRows
 
-	return percentErrs, m, nil
+	return totalParticipants, countOfErrs, m, rowErrs, nil
 }
 
-func rowKarateConverterKumite(arr []string) (age, kyi, dan uint8, cat pgtype.Int4range, kataGroup bool, weight float32, err error) {
-	ag, err := strconv.Atoi(arr[2])
-	if err != nil {
-		return
+// sameParticipant ищет среди участников с тем же karate.ParticipantKey участника p.
+func sameParticipant(list []karate.Participant, p karate.Participant) (karate.Participant, bool) {
+	for _, other := range list {
+		if karate.SameBirthDate(other.BirthDate, p.BirthDate) {
+			return other, true
+		}
+	}
+	return karate.Participant{}, false
+}
+
+// rowErrorOf собирает RowError из ошибки конвертера. Если ошибка не привязана к ячейке, строка помечается целиком.
+func rowErrorOf(rowIdx int, row []string, err error) RowError {
+	var cellErr *cellError
+	if errors.As(err, &cellErr) {
+		return toRowError(rowIdx, row, cellErr)
 	}
-	age = uint8(ag)
 
-	ky, err := strconv.Atoi(arr[3])
+	return RowError{Row: rowIdx + 1, Code: ERR_CODE_BAD_ROW, Message: "Не удалось распознать строку"}
+}
+
+func rowKarateConverterKumite(arr []string, cols columnMap) (age, kyi, dan uint8, birthDate time.Time, cat pgtype.Int4range, kataGroup bool, weight float32, err error) {
+	age, kyi, birthDate, kataGroup, err = rowKarateConverterCommon(arr, cols)
 	if err != nil {
 		return
 	}
-	kyi = uint8(ky)
 
-	wei, err := strconv.ParseFloat(arr[7], 32)
+	wei, err := parseCellFloat(cols.cell(arr, COL_WEIGHT), unitsWeight)
+	if err == nil {
+		err = checkRange(wei, MIN_WEIGHT, MAX_WEIGHT)
+	}
 	if err != nil {
+		err = newCellError(cols.index(COL_WEIGHT), ERR_CODE_BAD_WEIGHT,
+			fmt.Sprintf("Вес: %s. Укажите вес в килограммах, например 45.5", err))
 		return
 	}
 	weight = float32(wei)
 
-	arg := strings.ToLower(arr[6])
-	if strings.EqualFold(arg, "да") {
-		kataGroup = true
-	} else if strings.EqualFold(arg, "нет") {
-		kataGroup = false
+	// Категория проверяется по таблице karate_category уже в karate.Service: она зависит от возраста на день
+	// соревнований. Там же решается, можно ли её не указывать - соревнование может подбирать категорию по весу
+	cat, err = parseWeightCategory(cols.cell(arr, COL_CATEGORY))
+	if errors.Is(err, errEmptyValue) {
+		err = nil
+	} else if err != nil {
+		err = newCellError(cols.index(COL_CATEGORY), ERR_CODE_BAD_CATEGORY,
+			fmt.Sprintf("Весовая категория: %s. Укажите её, например, как \"-45\", \"45-50\" или \"70+\"", err))
+		return
 	}
 
-	absolute := strings.HasSuffix(arr[8], "+")
-	if absolute {
-		temp := strings.TrimRight(arr[8], "+")
-		err = cat.Set(fmt.Sprintf("[%s,)", temp))
-		if err != nil {
-			return
-		}
-	} else {
-		upper, err := strconv.Atoi(arr[8])
-		if err != nil {
-			return 0, 0, 0, pgtype.Int4range{}, false, 0, err
-		}
-		if age >= 18 {
-			err = cat.Set(fmt.Sprintf("[%d,%d)", upper-10, upper+1))
-
-			if err != nil {
-				return 0, 0, 0, pgtype.Int4range{}, false, 0, err
-			}
-		} else {
-			err = cat.Set(fmt.Sprintf("[%d,%d)", upper-5, upper+1))
+	dan, err = rowKarateConverterDan(arr, cols)
 
-			if err != nil {
-				return 0, 0, 0, pgtype.Int4range{}, false, 0, err
-			}
-		}
-
-	}
+	return
+}
 
-	da, err := strconv.Atoi(arr[10])
+func rowKarateConverterKata(arr []string, cols columnMap) (age, kyi, dan uint8, birthDate time.Time, kataGroup bool, err error) {
+	age, kyi, birthDate, kataGroup, err = rowKarateConverterCommon(arr, cols)
 	if err != nil {
 		return
 	}
-	dan = uint8(da)
+
+	dan, err = rowKarateConverterDan(arr, cols)
 
 	return
 }
 
-func rowKarateConverterKata(arr []string) (age, kyi, dan uint8, kataGroup bool, err error) {
-	ag, err := strconv.Atoi(arr[2])
+// rowKarateConverterCommon разбирает поля, общие для ката и кумите: возраст, дату рождения, кю и участие
+// в групповом ката. Если указана дата рождения, возраст считается по ней (на сегодня, на день соревнований его
+// пересчитает karate.Service), а столбец возраста не проверяется.
+func rowKarateConverterCommon(arr []string, cols columnMap) (age, kyi uint8, birthDate time.Time, kataGroup bool, err error) {
+	birthDate, err = rowKarateConverterBirthDate(arr, cols)
 	if err != nil {
 		return
 	}
-	age = uint8(ag)
 
-	ky, err := strconv.Atoi(arr[3])
+	if !birthDate.IsZero() {
+		age = karate.AgeOn(birthDate, now())
+	} else {
+		ag, err := parseCellInt(cols.cell(arr, COL_AGE), unitsAge)
+		if err == nil {
+			err = checkRange(float64(ag), 0, MAX_AGE)
+		}
+		if err != nil {
+			return 0, 0, time.Time{}, false, newCellError(cols.index(COL_AGE), ERR_CODE_BAD_AGE,
+				fmt.Sprintf("Возраст: %s. Укажите целое число полных лет", err))
+		}
+		age = uint8(ag)
+	}
+
+	ky, err := parseCellGrade(cols.cell(arr, COL_KYI), unitsKyi)
+	if err == nil {
+		err = checkRange(float64(ky), 0, MAX_GRADE)
+	}
 	if err != nil {
+		err = newCellError(cols.index(COL_KYI), ERR_CODE_BAD_KYI,
+			fmt.Sprintf("Кю: %s. Укажите кю целым числом от 0 до 10", err))
 		return
 	}
 	kyi = uint8(ky)
 
-	arg := strings.ToLower(arr[6])
+	arg := strings.ToLower(cols.cell(arr, COL_KATA_GROUP))
 	if strings.EqualFold(arg, "да") {
 		kataGroup = true
 	} else if strings.EqualFold(arg, "нет") {
 		kataGroup = false
 	}
 
-	da, err := strconv.Atoi(arr[10])
+	return
+}
+
+// rowKarateConverterBirthDate разбирает дату рождения. Столбец необязательный, пустая ячейка - нулевая дата.
+func rowKarateConverterBirthDate(arr []string, cols columnMap) (time.Time, error) {
+	val := cols.cell(arr, COL_BIRTH_DATE)
+	if val == "" {
+		return time.Time{}, nil
+	}
+
+	birthDate, ok := parseDate(val)
+	if !ok {
+		return time.Time{}, newCellError(cols.index(COL_BIRTH_DATE), ERR_CODE_BAD_BIRTH_DATE,
+			"Не удалось распознать дату рождения, укажите её в виде ДД.ММ.ГГГГ")
+	}
+	if birthDate.After(now()) {
+		return time.Time{}, newCellError(cols.index(COL_BIRTH_DATE), ERR_CODE_BAD_BIRTH_DATE,
+			"Дата рождения не может быть в будущем")
+	}
+
+	return birthDate, nil
+}
+
+// rowKarateConverterDan разбирает дан. Столбец необязательный: пустая ячейка или его отсутствие означает 0.
+func rowKarateConverterDan(arr []string, cols columnMap) (dan uint8, err error) {
+	val := cols.cell(arr, COL_DAN)
+	if val == "" {
+		return 0, nil
+	}
+
+	da, err := parseCellGrade(val, unitsDan)
+	if err == nil {
+		err = checkRange(float64(da), 0, MAX_GRADE)
+	}
 	if err != nil {
-		return
+		return 0, newCellError(cols.index(COL_DAN), ERR_CODE_BAD_DAN,
+			fmt.Sprintf("Дан: %s. Укажите дан целым числом от 0 до 10", err))
 	}
-	dan = uint8(da)
 
-	return
+	return uint8(da), nil
 }
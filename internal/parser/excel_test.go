@@ -0,0 +1,386 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/Geniuskaa/micro_registration/internal/sports/karate"
+	"github.com/xuri/excelize/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// go test ./internal/parser -run 'Test.*Golden' -update перезаписывает golden-файлы по текущему поведению
+// парсера. Изменения в testdata нужно просматривать так же внимательно, как изменения кода.
+var update = flag.Bool("update", false, "перезаписать golden-файлы в testdata")
+
+// Дата, на которую считается возраст по дате рождения, чтобы golden-файлы не менялись со временем
+var testNow = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+const testUUID = "6f1c2b7e-3d4a-4c5b-9e8f-0a1b2c3d4e5f"
+
+var testHeader = []interface{}{"ФИО", "Пол", "Возраст", "Кю", "Дан", "Город", "Ката/Кумите", "Ката группа", "Вес",
+	"Категория", "Тренер"}
+
+// xlsxFixture - заявка, которую тест собирает через excelize. Строки записываются на лист подряд с первой,
+// nil - пустая строка.
+type xlsxFixture struct {
+	name   string
+	rows   [][]interface{}
+	impl   Impl
+	sheet  string                 // Имя листа, по умолчанию SHEET_NAME
+	styled int                    // Кол-во пустых, но отформатированных строк после данных
+	after  func(f *excelize.File) // Правки книги, которые нельзя записать строками
+}
+
+// goldenParticipant - участник в golden-файле. Категория записывается так, как её пишут в заявке.
+type goldenParticipant struct {
+	karate.Participant
+	Category string `json:"category"`
+}
+
+type goldenResult struct {
+	Err          string              `json:"err,omitempty"`
+	SportType    string              `json:"sport_type,omitempty"`
+	UUID         string              `json:"uuid,omitempty"`
+	Meta         *Metadata           `json:"meta,omitempty"`
+	TotalRows    int                 `json:"total_rows"`
+	InvalidRows  int                 `json:"invalid_rows"`
+	PercentErrs  float64             `json:"percent_errs"`
+	Columns      map[string]string   `json:"columns,omitempty"`
+	Participants []goldenParticipant `json:"participants"`
+	RowErrors    []RowError          `json:"row_errors"`
+}
+
+// withMeta добавляет над заявкой шапку с UUID соревнования и клубом.
+func withMeta(rows ...[]interface{}) [][]interface{} {
+	head := [][]interface{}{
+		{"UUID", testUUID},
+		{"Клуб", "Сэмпай"},
+		nil,
+		testHeader,
+	}
+	return append(head, rows...)
+}
+
+func fixtures() []xlsxFixture {
+	spam := make([][]interface{}, 0, COUNTS_OF_LONG_ROWS_BEFORE_BLOCK_EXCEL+1)
+	for i := 0; i <= COUNTS_OF_LONG_ROWS_BEFORE_BLOCK_EXCEL; i++ {
+		row := make([]interface{}, MAX_LEN_OF_ROW+5)
+		for j := range row {
+			row[j] = "спам"
+		}
+		spam = append(spam, row)
+	}
+
+	return []xlsxFixture{
+		{
+			name: "kata_only",
+			rows: withMeta(
+				[]interface{}{"Иванов Иван Иванович", "м", 12, 8, 0, "Казань", "кат", "нет", "", "", "Петров П.П."},
+				[]interface{}{"петрова мария", "Ж", 15, 6, "", "", "Кат", "", "", "", "Петров П.П."},
+				[]interface{}{"Сидоров Пётр", "м", 10, 10, "", "Казань", "кат", "да", "", "", ""},
+			),
+		},
+		{
+			name: "kata_kumite",
+			rows: withMeta(
+				[]interface{}{"Иванов Иван", "м", 12, 8, "", "Казань", "кат/кум", "нет", 44.5, "-45", "Петров П.П."},
+				[]interface{}{"Смирнова Анна", "ж", 14, 7, "", "Казань", "кум", "", 48, "45-50", "Петров П.П."},
+				[]interface{}{"Кузнецов Олег", "м", 16, 5, "", "", "кат / кум", "", "61", "до 65", ""},
+				[]interface{}{"Попов Артём", "м", 13, 9, "", "", "кум", "", 40, "от 35 до 40 кг", ""},
+			),
+		},
+		{
+			name: "absolute_category",
+			rows: withMeta(
+				[]interface{}{"Волков Дмитрий Сергеевич", "м", 25, 1, 2, "Москва", "кум", "", 92.3, "70+", ""},
+				[]interface{}{"Лебедева Ольга", "ж", 30, 0, 1, "Москва", "кум", "", 71, "+65", ""},
+				[]interface{}{"Зайцев Игорь", "м", 19, 2, "", "", "кум", "", 85, "свыше 80", ""},
+			),
+		},
+		{
+			name:   "empty_rows",
+			styled: 50,
+			rows: withMeta(
+				nil,
+				[]interface{}{"Иванов Иван", "м", 12, 8, "", "", "кат", "", "", "", ""},
+				nil,
+				nil,
+				[]interface{}{"", "", "", "", "", "Казань", "", "", "", "", ""},
+				[]interface{}{"Петров Пётр", "м", 13, 7, "", "", "кат", "", "", "", ""},
+				nil,
+			),
+		},
+		{
+			name: "malformed_numbers",
+			rows: withMeta(
+				[]interface{}{"Иванов Иван", "м", "двенадцать", 8, "", "", "кат", "", "", "", ""},
+				[]interface{}{"Петров Пётр", "м", 12, "XI", "", "", "кат", "", "", "", ""},
+				[]interface{}{"Сидоров Олег", "м", 12, 8, "", "", "кум", "", "сорок", "-45", ""},
+				[]interface{}{"Смирнов Антон", "м", 12, 8, "", "", "кум", "", 44, "abc", ""},
+				[]interface{}{"Кузнецов Илья", "м", 12, 8, "", "", "кум", "", 44, "50-45", ""},
+				[]interface{}{"Попов Денис", "м", 12, 8, "", "", "кум", "", 500, "70+", ""},
+				[]interface{}{"Васильев Глеб", "м", 12, 8, 11, "", "кат", "", "", "", ""},
+				[]interface{}{"Морозов Павел", "м", 150, 8, "", "", "кат", "", "", "", ""},
+				[]interface{}{"Новиков Лев", "ч", 12, 8, "", "", "кат", "", "", "", ""},
+				[]interface{}{"Фёдоров", "м", 12, 8, "", "", "кат", "", "", "", ""},
+				[]interface{}{"Михайлов Марк", "м", 12, 8, "", "", "бег", "", "", "", ""},
+			),
+		},
+		{
+			name: "tolerant_numbers",
+			rows: withMeta(
+				[]interface{}{"Иванов Иван", "мужской", "12 лет", "8 кю", "", "", "кум", "", "44,5 кг", "–45", ""},
+				[]interface{}{"Петрова Анна", "жен.", "12.0", "VIII", "", "", "кат", "", "", "", ""},
+				[]interface{}{"Сидоров Олег", "М", " 13 ", "IV", "", "", "кум", "", "39.9", "35-40", ""},
+			),
+		},
+		{
+			name: "duplicates",
+			rows: withMeta(
+				[]interface{}{"Иванов Иван Иванович", "м", 12, 8, "", "", "кат", "", "", "", ""},
+				[]interface{}{"иванов  иван иванович", "м", 12, 7, "", "", "кат", "", "", "", ""},
+				[]interface{}{"Иванов Иван Иванович", "м", 13, 7, "", "", "кат", "", "", "", ""},
+			),
+		},
+		{
+			name: "birth_date",
+			rows: [][]interface{}{
+				{"UUID", testUUID},
+				{"ФИО", "Пол", "Дата рождения", "Кю", "Ката/Кумите"},
+				{"Иванов Иван", "м", time.Date(2011, time.February, 28, 0, 0, 0, 0, time.UTC), 8, "кат"},
+				{"Петрова Анна", "ж", "15.03.2011", 8, "кат"},
+				{"Сидоров Олег", "м", "01.01.2030", 8, "кат"},
+			},
+		},
+		{
+			name:  "header_not_first_sheet",
+			sheet: "Заявка",
+			rows: withMeta(
+				[]interface{}{"Иванов Иван", "м", 12, 8, "", "", "кат", "", "", "", ""},
+			),
+			after: func(f *excelize.File) {
+				f.NewSheet("Инструкция")
+				f.SetSheetRow("Инструкция", "A1", &[]interface{}{"Заполните лист \"Заявка\""})
+			},
+		},
+		{
+			name: "missing_columns",
+			rows: [][]interface{}{
+				{"ФИО", "Пол", "Город", "Тренер"},
+				{"Иванов Иван", "м", "Казань", ""},
+			},
+		},
+		{
+			name: "no_participants",
+			rows: withMeta(nil, nil),
+		},
+		{
+			name: "long_spam_rows",
+			rows: append(withMeta(
+				[]interface{}{"Иванов Иван", "м", 12, 8, "", "", "кат", "", "", "", ""},
+			), spam...),
+		},
+		{
+			name: "too_many_rows",
+			impl: Impl{Limits: Limits{MaxRows: 6}},
+			rows: withMeta(
+				[]interface{}{"Иванов Иван", "м", 12, 8, "", "", "кат", "", "", "", ""},
+				[]interface{}{"Петров Пётр", "м", 12, 8, "", "", "кат", "", "", "", ""},
+				[]interface{}{"Сидоров Олег", "м", 12, 8, "", "", "кат", "", "", "", ""},
+			),
+		},
+	}
+}
+
+// buildXlsx записывает строки фикстуры в новую книгу и возвращает её содержимое.
+func buildXlsx(t testing.TB, fx xlsxFixture) []byte {
+	t.Helper()
+
+	f := excelize.NewFile()
+	defer f.Close()
+
+	sheet := fx.sheet
+	if sheet == "" {
+		sheet = SHEET_NAME
+	}
+	f.SetSheetName(f.GetSheetName(0), sheet)
+
+	for i, row := range fx.rows {
+		if row == nil {
+			continue
+		}
+		cell, err := excelize.CoordinatesToCellName(1, i+1)
+		if err != nil {
+			t.Fatalf("excelize.CoordinatesToCellName failed: %v", err)
+		}
+		if err := f.SetSheetRow(sheet, cell, &row); err != nil {
+			t.Fatalf("f.SetSheetRow failed: %v", err)
+		}
+		for j, v := range row {
+			if _, ok := v.(time.Time); !ok {
+				continue
+			}
+			if err := setDateStyle(f, sheet, j+1, i+1); err != nil {
+				t.Fatalf("setDateStyle failed: %v", err)
+			}
+		}
+	}
+
+	if fx.styled > 0 {
+		style, err := f.NewStyle(&excelize.Style{Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#FFFF00"}}})
+		if err != nil {
+			t.Fatalf("f.NewStyle failed: %v", err)
+		}
+		first, _ := excelize.CoordinatesToCellName(1, len(fx.rows)+1)
+		last, _ := excelize.CoordinatesToCellName(len(testHeader), len(fx.rows)+fx.styled)
+		if err := f.SetCellStyle(sheet, first, last, style); err != nil {
+			t.Fatalf("f.SetCellStyle failed: %v", err)
+		}
+	}
+
+	if fx.after != nil {
+		fx.after(f)
+	}
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatalf("f.WriteToBuffer failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func setDateStyle(f *excelize.File, sheet string, col, row int) error {
+	cell, err := excelize.CoordinatesToCellName(col, row)
+	if err != nil {
+		return err
+	}
+	style, err := f.NewStyle(&excelize.Style{NumFmt: 14})
+	if err != nil {
+		return err
+	}
+	return f.SetCellStyle(sheet, cell, cell, style)
+}
+
+// goldenOf приводит результат разбора к виду, не зависящему от порядка обхода мапы участников.
+func goldenOf(resp *Response, err error) goldenResult {
+	if err != nil {
+		return goldenResult{Err: err.Error(), Participants: []goldenParticipant{}, RowErrors: []RowError{}}
+	}
+
+	g := goldenResult{
+		SportType:    resp.SportType,
+		UUID:         resp.UUID,
+		Meta:         &resp.Meta,
+		TotalRows:    resp.TotalRows,
+		InvalidRows:  resp.InvalidRows,
+		PercentErrs:  resp.PercentErrs,
+		Columns:      resp.Columns,
+		Participants: make([]goldenParticipant, 0, len(resp.Map)),
+		RowErrors:    resp.RowErrors,
+	}
+	for _, v := range resp.Map {
+		p := v.(karate.Participant)
+		g.Participants = append(g.Participants, goldenParticipant{Participant: p, Category: karate.FormatWeightCategory(p.Category)})
+	}
+	sort.Slice(g.Participants, func(i, j int) bool {
+		return g.Participants[i].Row < g.Participants[j].Row
+	})
+	if g.RowErrors == nil {
+		g.RowErrors = []RowError{}
+	}
+	return g
+}
+
+func TestParseXlsxGolden(t *testing.T) {
+	defer func(orig func() time.Time) { now = orig }(now)
+	now = func() time.Time { return testNow }
+
+	for _, fx := range fixtures() {
+		fx := fx
+		t.Run(fx.name, func(t *testing.T) {
+			data := buildXlsx(t, fx)
+
+			checkGolden(t, fx.name, goldenOf(fx.impl.ParseXlsx(bytes.NewReader(data))))
+		})
+	}
+}
+
+// csvFixtures - заявки в CSV из testdata/<имя>.csv.
+var csvFixtures = []string{"csv_line_numbers"}
+
+func TestParseCsvGolden(t *testing.T) {
+	defer func(orig func() time.Time) { now = orig }(now)
+	now = func() time.Time { return testNow }
+
+	for _, name := range csvFixtures {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name+".csv"))
+			if err != nil {
+				t.Fatalf("os.ReadFile failed: %v", err)
+			}
+
+			checkGolden(t, name, goldenOf(Impl{}.Parse(bytes.NewReader(data), name+".csv")))
+		})
+	}
+}
+
+// checkGolden сравнивает результат разбора с testdata/<имя>.golden.json, а с -update перезаписывает его.
+func checkGolden(t *testing.T, name string, result goldenResult) {
+	t.Helper()
+
+	got, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		t.Fatalf("json.MarshalIndent failed: %v", err)
+	}
+	got = append(got, '\n')
+
+	path := filepath.Join("testdata", name+".golden.json")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("os.WriteFile failed: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("os.ReadFile failed: %v (запустите тест с -update, чтобы создать golden-файл)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("результат разбора %s отличается от %s:\n%s", name, path, lineDiff(string(want), string(got)))
+	}
+}
+
+// lineDiff показывает первые отличающиеся строки, чтобы не искать разницу в JSON на сотни строк глазами.
+func lineDiff(want, got string) string {
+	wl, gl := strings.Split(want, "\n"), strings.Split(got, "\n")
+	var b strings.Builder
+	shown := 0
+	for i := 0; i < len(wl) || i < len(gl); i++ {
+		var w, g string
+		if i < len(wl) {
+			w = wl[i]
+		}
+		if i < len(gl) {
+			g = gl[i]
+		}
+		if w == g {
+			continue
+		}
+		fmt.Fprintf(&b, "строка %d:\n  -%s\n  +%s\n", i+1, w, g)
+		shown++
+		if shown == 10 {
+			b.WriteString("  ...\n")
+			break
+		}
+	}
+	return b.String()
+}
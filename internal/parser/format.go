@@ -0,0 +1,141 @@
+package parser
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Форматы файлов заявок, которые умеет читать парсер.
+const (
+	FORMAT_XLSX = "xlsx"
+	FORMAT_XLS  = "xls"
+	FORMAT_ODS  = "ods"
+	FORMAT_CSV  = "csv"
+
+	odsMimeType = "application/vnd.oasis.opendocument.spreadsheet"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("Формат файла не поддерживается. Отправьте заявку в формате .xlsx, .xls, .ods или .csv")
+
+	zipMagic = []byte("PK\x03\x04")
+
+	supportedExtensions = map[string]string{
+		".xlsx": FORMAT_XLSX,
+		".xls":  FORMAT_XLS,
+		".ods":  FORMAT_ODS,
+		".csv":  FORMAT_CSV,
+	}
+)
+
+// IsSupported проверяет по имени файла, стоит ли вообще отдавать вложение парсеру.
+func IsSupported(filename string) bool {
+	_, ok := supportedExtensions[strings.ToLower(filepath.Ext(filename))]
+	return ok
+}
+
+// Parse - точка входа для файла любого поддерживаемого формата. Формат определяется по содержимому, имя файла
+// используется как подсказка, когда по содержимому его не понять (CSV - это просто текст).
+func (i Impl) Parse(r io.Reader, filename string) (*Response, error) {
+	limits := i.Limits.withDefaults()
+
+	data, err := readLimited(r, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := detectFormat(data, filename)
+	if err != nil {
+		return nil, fmt.Errorf("detectFormat failed: %w", err)
+	}
+
+	switch format {
+	case FORMAT_XLSX:
+		wb, err := openXlsx(data, limits)
+		if err != nil {
+			return nil, err
+		}
+		defer wb.close()
+
+		return i.parseWorkbook(wb)
+	case FORMAT_XLS:
+		wb, err := readXls(data, limits)
+		if err != nil {
+			return nil, err
+		}
+		return i.parseWorkbook(wb)
+	case FORMAT_ODS:
+		wb, err := readOds(data, limits)
+		if err != nil {
+			return nil, err
+		}
+		return i.parseWorkbook(wb)
+	default:
+		rows, err := readCsv(data, limits)
+		if err != nil {
+			return nil, err
+		}
+		return i.parseWorkbook(memWorkbook{{sheetInfo: sheetInfo{name: CSV_SHEET_NAME}, rows: rows}})
+	}
+}
+
+func detectFormat(data []byte, filename string) (string, error) {
+	if bytes.HasPrefix(data, zipMagic) {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return "", fmt.Errorf("zip.NewReader failed: %w", err)
+		}
+
+		for _, f := range zr.File {
+			switch f.Name {
+			case "xl/workbook.xml":
+				return FORMAT_XLSX, nil
+			case "mimetype":
+				// Формат определяется до checkZip, поэтому читаем не больше, чем нужно для сравнения
+				mime, err := readZipFile(f, int64(len(odsMimeType))+1)
+				if err != nil {
+					return "", err
+				}
+				if strings.TrimSpace(string(mime)) == odsMimeType {
+					return FORMAT_ODS, nil
+				}
+			}
+		}
+
+		return "", ErrUnsupportedFormat
+	}
+
+	// Составной документ OLE - это книга Excel 97-2003
+	if bytes.HasPrefix(data, cfbMagic) {
+		return FORMAT_XLS, nil
+	}
+
+	// Бинарный файл, не являющийся zip-архивом, CSV быть не может. Файл без расширения тоже пробуем читать как CSV.
+	ext := strings.ToLower(filepath.Ext(filename))
+	if (ext == "" || supportedExtensions[ext] == FORMAT_CSV) && !bytes.ContainsRune(data, 0) {
+		return FORMAT_CSV, nil
+	}
+
+	return "", ErrUnsupportedFormat
+}
+
+// readZipFile читает не больше max байт файла из архива: заявленному в архиве размеру файла доверять нельзя.
+func readZipFile(f *zip.File, max int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("f.Open failed: %w", err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, max))
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll failed: %w", err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,41 @@
+package parser
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Формат определяется до проверки архива, и огромный файл mimetype не должен распаковываться в память целиком.
+func TestDetectFormatOversizedMimetype(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	w, err := zw.Create("mimetype")
+	if err != nil {
+		t.Fatalf("zw.Create failed: %v", err)
+	}
+	padding := strings.Repeat(" ", 1<<20)
+	w.Write([]byte(odsMimeType))
+	for i := 0; i < 64; i++ {
+		w.Write([]byte(padding))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zw.Close failed: %v", err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = Impl{}.Parse(bytes.NewReader(buf.Bytes()), "Заявка.ods")
+	runtime.ReadMemStats(&after)
+
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || !errors.Is(err, ErrUnzipTooLarge) {
+		t.Fatalf("err = %v, want ErrUnzipTooLarge", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 16<<20 {
+		t.Errorf("Parse allocated %d bytes", allocated)
+	}
+}
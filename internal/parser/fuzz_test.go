@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"github.com/jackc/pgtype"
+	"strings"
+	"testing"
+)
+
+// Фаззинг запускается отдельно для каждой цели, например:
+//
+//	go test ./internal/parser -run '^$' -fuzz FuzzParseXlsx -fuzztime 1m
+//
+// Без -fuzz цели прогоняются только на начальном корпусе, как обычные тесты.
+
+func FuzzParseXlsx(f *testing.F) {
+	for _, fx := range fixtures() {
+		f.Add(buildXlsx(f, fx))
+	}
+	f.Add([]byte{})
+	f.Add([]byte("PK\x03\x04"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		resp, err := Impl{}.ParseXlsx(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		checkResponse(t, resp)
+	})
+}
+
+// checkResponse проверяет инварианты успешного разбора, которые не зависят от содержимого заявки.
+func checkResponse(t *testing.T, resp *Response) {
+	t.Helper()
+
+	if resp == nil {
+		t.Fatal("ParseXlsx returned nil response without error")
+	}
+	if resp.TotalRows <= 0 || resp.InvalidRows < 0 || resp.InvalidRows > resp.TotalRows {
+		t.Fatalf("bad row counters: total %d, invalid %d", resp.TotalRows, resp.InvalidRows)
+	}
+	if len(resp.Map) > resp.TotalRows-resp.InvalidRows {
+		t.Fatalf("%d participants from %d valid rows", len(resp.Map), resp.TotalRows-resp.InvalidRows)
+	}
+	if resp.PercentErrs < 0 || resp.PercentErrs > 100 {
+		t.Fatalf("bad percent of errors: %v", resp.PercentErrs)
+	}
+}
+
+// fuzzRow собирает строку участника по заголовку testHeader. width обрезает строку, как это бывает в файлах, где
+// последние пустые ячейки не записаны.
+func fuzzRow(width uint8, age, kyi, dan, kataGroup, weight, category string) ([]string, columnMap) {
+	header := make([]string, 0, len(testHeader))
+	for _, h := range testHeader {
+		header = append(header, h.(string))
+	}
+	cols := mapHeaderRow(header)
+
+	row := make([]string, len(header))
+	row[cols[COL_FULLNAME]] = "Иванов Иван"
+	row[cols[COL_SEX]] = "м"
+	row[cols[COL_AGE]] = age
+	row[cols[COL_KYI]] = kyi
+	row[cols[COL_DAN]] = dan
+	row[cols[COL_KATA_GROUP]] = kataGroup
+	row[cols[COL_WEIGHT]] = weight
+	row[cols[COL_CATEGORY]] = category
+
+	if int(width) < len(row) {
+		row = row[:width]
+	}
+	return row, cols
+}
+
+func FuzzRowKarateConverterKumite(f *testing.F) {
+	f.Add(uint8(11), "12", "8", "", "нет", "44.5", "-45")
+	f.Add(uint8(11), "25 лет", "I", "2", "да", "92,3 кг", "70+")
+	f.Add(uint8(11), "16", "5 кю", "", "", "61", "от 60 до 65")
+	f.Add(uint8(9), "13", "IX", "", "", "40", "")
+	f.Add(uint8(3), "", "", "", "", "", "")
+	f.Add(uint8(11), "-1", "11", "-5", "может", "1e309", "99999999999-1")
+
+	f.Fuzz(func(t *testing.T, width uint8, age, kyi, dan, kataGroup, weight, category string) {
+		row, cols := fuzzRow(width, age, kyi, dan, kataGroup, weight, category)
+
+		gotAge, gotKyi, gotDan, _, cat, _, gotWeight, err := rowKarateConverterKumite(row, cols)
+		if err != nil {
+			var cellErr *cellError
+			if !errors.As(err, &cellErr) {
+				t.Fatalf("error is not bound to a cell: %v", err)
+			}
+			return
+		}
+
+		if gotAge > MAX_AGE || gotKyi > MAX_GRADE || gotDan > MAX_GRADE {
+			t.Fatalf("value out of range: age %d, kyi %d, dan %d", gotAge, gotKyi, gotDan)
+		}
+		if gotWeight < MIN_WEIGHT || gotWeight > MAX_WEIGHT {
+			t.Fatalf("weight out of range: %v", gotWeight)
+		}
+		if strings.TrimSpace(cols.cell(row, COL_CATEGORY)) != "" && cat.Status != pgtype.Present {
+			t.Fatalf("category %q parsed without a range", cols.cell(row, COL_CATEGORY))
+		}
+	})
+}
+
+func FuzzRowKarateConverterKata(f *testing.F) {
+	f.Add(uint8(11), "12", "8", "", "нет")
+	f.Add(uint8(11), "10", "X", "1", "да")
+	f.Add(uint8(5), "12.0", "VIII", "", "")
+	f.Add(uint8(0), "", "", "", "")
+
+	f.Fuzz(func(t *testing.T, width uint8, age, kyi, dan, kataGroup string) {
+		row, cols := fuzzRow(width, age, kyi, dan, kataGroup, "", "")
+
+		gotAge, gotKyi, gotDan, _, _, err := rowKarateConverterKata(row, cols)
+		if err != nil {
+			var cellErr *cellError
+			if !errors.As(err, &cellErr) {
+				t.Fatalf("error is not bound to a cell: %v", err)
+			}
+			return
+		}
+
+		if gotAge > MAX_AGE || gotKyi > MAX_GRADE || gotDan > MAX_GRADE {
+			t.Fatalf("value out of range: age %d, kyi %d, dan %d", gotAge, gotKyi, gotDan)
+		}
+	})
+}
@@ -0,0 +1,211 @@
+package parser
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// Значения ограничений по умолчанию. Файлы заявок приходят из почты от кого угодно, поэтому всё, что парсер читает,
+// ограничено: размер вложения, степень сжатия zip-архива (xlsx и ods - это zip), кол-во строк и длина ячейки.
+const (
+	MAX_FILE_SIZE         = 5 << 20  // 5 МБ - с большим запасом для заявки на 1500 участников
+	MAX_UNZIP_SIZE        = 50 << 20 // Суммарный размер распакованного xlsx/ods
+	MAX_COMPRESSION_RATIO = 100      // Во сколько раз файл внутри архива может быть больше, чем в сжатом виде
+	MAX_LEN_OF_CELL       = 512      // В символах
+	MAX_SHEETS            = 64       // Листов в заявке обычно один-два, но книгу могут прислать вместе с черновиками
+
+	// Маленькие файлы внутри архива бывают сжаты очень сильно и при этом безопасны, их степень сжатия не проверяем
+	ZIP_RATIO_CHECK_MIN_SIZE = 1 << 20
+)
+
+var (
+	ErrFileTooLarge     = errors.New("Файл заявки слишком большой")
+	ErrUnzipTooLarge    = errors.New("Распакованное содержимое файла заявки слишком большое")
+	ErrCompressionRatio = errors.New("Файл заявки подозрительно сильно сжат")
+	ErrTooManyRows      = errors.New("В заявке слишком много строк")
+	ErrCellTooLong      = errors.New("В заявке есть слишком длинная ячейка")
+	ErrSpamRows         = errors.New("В заявке слишком много длинных строк, файл похож на спам")
+	ErrTooManySheets    = errors.New("В файле заявки слишком много листов")
+)
+
+// Limits - ограничения, при превышении которых парсер прекращает чтение файла. Нулевое поле означает значение
+// по умолчанию.
+type Limits struct {
+	MaxFileSize         int64   // Размер вложения в байтах
+	MaxUnzipSize        int64   // Суммарный размер распакованных файлов xlsx/ods в байтах
+	MaxCompressionRatio float64 // Максимальная степень сжатия одного файла внутри архива
+	MaxRows             int     // Кол-во строк листа
+	MaxLenOfCell        int     // Длина значения ячейки в символах
+	MaxSheets           int     // Кол-во листов книги xls и ods
+
+	// Защита от спама: строки длиннее MaxLenOfRow ячеек допустимы, но не больше CountsOfLongRowsBeforeBlock штук
+	MaxLenOfRow                 int
+	CountsOfLongRowsBeforeBlock int
+}
+
+// LimitError - превышение одного из ограничений Limits. Err - одна из ошибок ErrFileTooLarge, ErrTooManyRows и т.д.
+type LimitError struct {
+	Err    error
+	Limit  int64
+	Actual int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s (допустимо %d, получено %d)", e.Err.Error(), e.Limit, e.Actual)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		MaxFileSize:                 MAX_FILE_SIZE,
+		MaxUnzipSize:                MAX_UNZIP_SIZE,
+		MaxCompressionRatio:         MAX_COMPRESSION_RATIO,
+		MaxRows:                     MAX_COUNT_OF_ROWS,
+		MaxLenOfCell:                MAX_LEN_OF_CELL,
+		MaxSheets:                   MAX_SHEETS,
+		MaxLenOfRow:                 MAX_LEN_OF_ROW,
+		CountsOfLongRowsBeforeBlock: COUNTS_OF_LONG_ROWS_BEFORE_BLOCK_EXCEL,
+	}
+}
+
+// withDefaults подставляет значения по умолчанию в незаполненные поля.
+func (l Limits) withDefaults() Limits {
+	d := DefaultLimits()
+	if l.MaxFileSize <= 0 {
+		l.MaxFileSize = d.MaxFileSize
+	}
+	if l.MaxUnzipSize <= 0 {
+		l.MaxUnzipSize = d.MaxUnzipSize
+	}
+	if l.MaxCompressionRatio <= 0 {
+		l.MaxCompressionRatio = d.MaxCompressionRatio
+	}
+	if l.MaxRows <= 0 {
+		l.MaxRows = d.MaxRows
+	}
+	if l.MaxLenOfCell <= 0 {
+		l.MaxLenOfCell = d.MaxLenOfCell
+	}
+	if l.MaxSheets <= 0 {
+		l.MaxSheets = d.MaxSheets
+	}
+	if l.MaxLenOfRow <= 0 {
+		l.MaxLenOfRow = d.MaxLenOfRow
+	}
+	if l.CountsOfLongRowsBeforeBlock <= 0 {
+		l.CountsOfLongRowsBeforeBlock = d.CountsOfLongRowsBeforeBlock
+	}
+	return l
+}
+
+// readLimited читает вложение целиком, но не больше MaxFileSize байт.
+func readLimited(r io.Reader, l Limits) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, l.MaxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("io.ReadAll failed: %w", err)
+	}
+
+	if int64(len(data)) > l.MaxFileSize {
+		return nil, &LimitError{Err: ErrFileTooLarge, Limit: l.MaxFileSize, Actual: int64(len(data))}
+	}
+
+	return data, nil
+}
+
+// checkZip проверяет заголовки zip-архива до распаковки. Размеры берутся из центрального каталога: archive/zip
+// не даст прочитать из файла больше, чем в нём заявлено, поэтому обойти проверку подменой заголовка нельзя.
+func checkZip(data []byte, l Limits) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("zip.NewReader failed: %w", err)
+	}
+
+	var total uint64
+	for _, f := range zr.File {
+		total += f.UncompressedSize64
+		if total > uint64(l.MaxUnzipSize) {
+			return &LimitError{Err: ErrUnzipTooLarge, Limit: l.MaxUnzipSize, Actual: int64(total)}
+		}
+
+		if f.UncompressedSize64 < ZIP_RATIO_CHECK_MIN_SIZE {
+			continue
+		}
+		if f.CompressedSize64 == 0 || float64(f.UncompressedSize64)/float64(f.CompressedSize64) > l.MaxCompressionRatio {
+			ratio := int64(0)
+			if f.CompressedSize64 != 0 {
+				ratio = int64(f.UncompressedSize64 / f.CompressedSize64)
+			}
+			return &LimitError{Err: ErrCompressionRatio, Limit: int64(l.MaxCompressionRatio), Actual: ratio}
+		}
+	}
+
+	return nil
+}
+
+// rowLimiter проверяет строки листа по мере чтения, чтобы остановиться на первой же строке сверх ограничений.
+type rowLimiter struct {
+	limits   Limits
+	longRows int
+}
+
+func newRowLimiter(l Limits) *rowLimiter {
+	return &rowLimiter{limits: l}
+}
+
+// check проверяет строку с номером rowNum (с 1). Пустые строки не проверяются: лист может быть отформатирован
+// далеко за пределами заявки, и это не повод отклонять файл.
+func (rl *rowLimiter) check(rowNum int, row []string) error {
+	if isEmptyRow(row) {
+		return nil
+	}
+
+	if rowNum > rl.limits.MaxRows {
+		return &LimitError{Err: ErrTooManyRows, Limit: int64(rl.limits.MaxRows), Actual: int64(rowNum)}
+	}
+
+	for _, cell := range row {
+		// Сначала сравниваем байты, чтобы не считать руны у коротких ячеек
+		if len(cell) > rl.limits.MaxLenOfCell && utf8.RuneCountInString(cell) > rl.limits.MaxLenOfCell {
+			return &LimitError{Err: ErrCellTooLong, Limit: int64(rl.limits.MaxLenOfCell),
+				Actual: int64(utf8.RuneCountInString(cell))}
+		}
+	}
+
+	// Если часто (задаётся в Limits) попадаются длинные строки, система сочтёт это за спам
+	if len(row) > rl.limits.MaxLenOfRow {
+		rl.longRows++
+		if rl.longRows > rl.limits.CountsOfLongRowsBeforeBlock {
+			return &LimitError{Err: ErrSpamRows, Limit: int64(rl.limits.CountsOfLongRowsBeforeBlock),
+				Actual: int64(rl.longRows)}
+		}
+	}
+
+	return nil
+}
+
+// checkRows прогоняет через ограничения уже прочитанный лист.
+func checkRows(rows [][]string, l Limits) error {
+	rl := newRowLimiter(l)
+	for i, row := range rows {
+		if err := rl.check(i+1, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isEmptyRow(row []string) bool {
+	for _, cell := range row {
+		if cell != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,190 @@
+package parser
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Ключи полей блока метаинформации над таблицей участников.
+const (
+	META_UUID            = "uuid"
+	META_CLUB            = "club"
+	META_EMAIL           = "email"
+	META_PHONE           = "phone"
+	META_CITY            = "city"
+	META_SUBMISSION_DATE = "submission_date"
+)
+
+var (
+	ErrUUIDNotFound = errors.New("В заявке не указан идентификатор соревнования (UUID)")
+	ErrInvalidUUID  = errors.New("Идентификатор соревнования (UUID) указан в неверном формате")
+
+	uuidRegex  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	emailRegex = regexp.MustCompile(`^[A-Za-z0-9._+%-]+@[A-Za-z0-9.-]+[.][A-Za-z]+$`) // Совпадает с доменом mail в БД
+	phoneRegex = regexp.MustCompile(`^\+?[0-9]{10,15}$`)
+
+	// Допустимые подписи полей метаинформации (сравниваются после normalizeHeader)
+	metaLabels = func() map[string]string {
+		labels := map[string][]string{
+			META_UUID:            {"UUID", "Идентификатор", "Идентификатор соревнования", "Код соревнования", "ID соревнования"},
+			META_CLUB:            {"Клуб", "Команда", "Название клуба", "Спортивный клуб", "Организация"},
+			META_EMAIL:           {"Email", "E-mail", "Почта", "Эл. почта", "Электронная почта"},
+			META_PHONE:           {"Телефон", "Тел.", "Контактный телефон"},
+			META_CITY:            {"Город", "Город клуба"},
+			META_SUBMISSION_DATE: {"Дата подачи", "Дата подачи заявки", "Дата заявки"},
+		}
+
+		m := make(map[string]string, 30)
+		for key, list := range labels {
+			for _, l := range list {
+				m[normalizeHeader(l)] = key
+			}
+		}
+		return m
+	}()
+)
+
+// Metadata - сведения о заявке, которые тренер заполняет над таблицей участников.
+type Metadata struct {
+	UUID           string    `json:"uuid"`
+	Club           string    `json:"club"`
+	CoachEmail     string    `json:"coach_email"`
+	CoachPhone     string    `json:"coach_phone"`
+	City           string    `json:"city"`
+	SubmissionDate time.Time `json:"submission_date"`
+}
+
+// metadataParser читает строки над строкой заголовков. Поле задаётся подписью и значением в соседней ячейке
+// ("Клуб" | "Сэмпай") либо в одной ячейке через двоеточие ("Клуб: Сэмпай"). UUID без подписи тоже распознаётся,
+// так заполнялись заявки старого образца. Некорректные необязательные поля не блокируют файл, а попадают в RowError.
+func metadataParser(rows [][]string, headerIdx int) (Metadata, []RowError, error) {
+	meta := Metadata{}
+	rowErrs := make([]RowError, 0)
+
+	for i := 0; i < headerIdx && i < len(rows); i++ {
+		row := rows[i]
+		for j := 0; j < len(row); j++ {
+			cell := strings.TrimSpace(row[j])
+			if cell == "" {
+				continue
+			}
+
+			if uuidRegex.MatchString(cell) {
+				if meta.UUID == "" {
+					meta.UUID = cell
+				}
+				continue
+			}
+
+			key, value, valueCol := metaField(row, j)
+			if key == "" {
+				continue
+			}
+			j = valueCol
+
+			if value == "" {
+				continue
+			}
+
+			switch key {
+			case META_UUID:
+				if !uuidRegex.MatchString(value) {
+					return meta, rowErrs, ErrInvalidUUID
+				}
+				meta.UUID = value
+			case META_CLUB:
+				meta.Club = value
+			case META_CITY:
+				meta.City = value
+			case META_EMAIL:
+				if !emailRegex.MatchString(value) {
+					rowErrs = append(rowErrs, toRowError(i, row, newCellError(valueCol, ERR_CODE_BAD_META,
+						"Некорректный адрес электронной почты")))
+					continue
+				}
+				meta.CoachEmail = value
+			case META_PHONE:
+				phone := normalizePhone(value)
+				if !phoneRegex.MatchString(phone) {
+					rowErrs = append(rowErrs, toRowError(i, row, newCellError(valueCol, ERR_CODE_BAD_META,
+						"Некорректный номер телефона")))
+					continue
+				}
+				meta.CoachPhone = phone
+			case META_SUBMISSION_DATE:
+				date, ok := parseDate(value)
+				if !ok {
+					rowErrs = append(rowErrs, toRowError(i, row, newCellError(valueCol, ERR_CODE_BAD_META,
+						"Дата подачи заявки должна быть в формате ДД.ММ.ГГГГ")))
+					continue
+				}
+				meta.SubmissionDate = date
+			}
+		}
+	}
+
+	if meta.UUID == "" {
+		return meta, rowErrs, ErrUUIDNotFound
+	}
+
+	return meta, rowErrs, nil
+}
+
+// metaField распознаёт подпись поля в ячейке col и возвращает ключ поля, его значение и индекс ячейки со значением.
+func metaField(row []string, col int) (key, value string, valueCol int) {
+	cell := row[col]
+
+	if label, val, found := strings.Cut(cell, ":"); found && strings.TrimSpace(val) != "" {
+		if key, ok := metaLabels[normalizeHeader(label)]; ok {
+			return key, strings.TrimSpace(val), col
+		}
+	}
+
+	key, ok := metaLabels[normalizeHeader(cell)]
+	if !ok {
+		return "", "", col
+	}
+
+	// Значение может стоять не в соседней ячейке, если между ними пустые. Но если поле не заполнено, следующей
+	// непустой ячейкой окажется подпись другого поля, и её значением считать нельзя
+	for j := col + 1; j < len(row); j++ {
+		v := strings.TrimSpace(row[j])
+		if v == "" {
+			continue
+		}
+		if isMetaLabel(v) {
+			break
+		}
+		return key, v, j
+	}
+
+	return key, "", col
+}
+
+// isMetaLabel проверяет, что в ячейке подпись поля метаинформации, в т.ч. вместе со значением ("Город: Казань").
+func isMetaLabel(cell string) bool {
+	if _, ok := metaLabels[normalizeHeader(cell)]; ok {
+		return true
+	}
+	label, _, found := strings.Cut(cell, ":")
+	_, ok := metaLabels[normalizeHeader(label)]
+	return found && ok
+}
+
+// normalizePhone убирает из номера пробелы, скобки и дефисы. Российский номер с 8 в начале приводится к +7.
+func normalizePhone(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if (r >= '0' && r <= '9') || (r == '+' && i == 0) {
+			b.WriteRune(r)
+		}
+	}
+
+	phone := b.String()
+	if len(phone) == 11 && strings.HasPrefix(phone, "8") {
+		phone = "+7" + phone[1:]
+	}
+	return phone
+}
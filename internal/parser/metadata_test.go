@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"testing"
+)
+
+func TestMetadataParser(t *testing.T) {
+	tests := []struct {
+		name string
+		rows [][]string
+		want Metadata
+	}{
+		{
+			name: "adjacent",
+			rows: [][]string{{"UUID", testUUID}, {"Клуб", "Сэмпай", "Email", "coach@mail.ru"}},
+			want: Metadata{UUID: testUUID, Club: "Сэмпай", CoachEmail: "coach@mail.ru"},
+		},
+		{
+			name: "gap_before_value",
+			rows: [][]string{{"UUID", "", "", testUUID}, {"Клуб", "", "Сэмпай"}},
+			want: Metadata{UUID: testUUID, Club: "Сэмпай"},
+		},
+		{
+			name: "empty_value_before_label",
+			rows: [][]string{{"UUID", testUUID}, {"Клуб", "", "Email", "coach@mail.ru"}},
+			want: Metadata{UUID: testUUID, CoachEmail: "coach@mail.ru"},
+		},
+		{
+			name: "empty_value_before_label_with_colon",
+			rows: [][]string{{"Клуб", "", "Город: Казань"}, {testUUID}},
+			want: Metadata{UUID: testUUID, City: "Казань"},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			rows := append(tt.rows, []string{"ФИО"})
+			got, rowErrs, err := metadataParser(rows, len(rows)-1)
+			if err != nil || len(rowErrs) != 0 {
+				t.Fatalf("metadataParser failed: %v, %+v", err, rowErrs)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package parser
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// LibreOffice дописывает в конец листа пустые строки и столбцы с огромным number-*-repeated. Пустые ячейки
+// разворачиваются только если за ними идут данные, а столбцы дальше ODS_MAX_COLUMNS не читаются вовсе.
+const ODS_MAX_COLUMNS = 1024
+
+var (
+	errOdsContentNotFound = errors.New("content.xml not found in ods archive")
+)
+
+// readOds читает все листы ODS-документа. Степень сжатия архива проверяется до распаковки content.xml.
+func readOds(data []byte, l Limits) (memWorkbook, error) {
+	if err := checkZip(data, l); err != nil {
+		return nil, err
+	}
+	return readOdsTables(data, l)
+}
+
+func readOdsTables(data []byte, l Limits) (memWorkbook, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("zip.NewReader failed: %w", err)
+	}
+
+	for _, f := range zr.File {
+		if f.Name != "content.xml" {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("f.Open failed: %w", err)
+		}
+		defer rc.Close()
+
+		return decodeOdsContent(rc, l)
+	}
+
+	return nil, fmt.Errorf("readOdsTables failed: %w", errOdsContentNotFound)
+}
+
+// decodeOdsContent потоково разбирает content.xml: table:table -> table:table-row -> table:table-cell.
+// Скрытые листы определяются по автоматическим стилям с table:display="false", которые идут до тела документа.
+// Каждая строка проверяется по Limits сразу после чтения.
+func decodeOdsContent(r io.Reader, l Limits) (memWorkbook, error) {
+	d := xml.NewDecoder(r)
+	tables := make(memWorkbook, 0, 1)
+	hiddenStyles := make(map[string]bool)
+
+	var (
+		styleName    string
+		table        *sheet
+		limiter      *rowLimiter
+		row          []string
+		rowRepeat    int
+		pendingCells int // Пустые ячейки, которые ещё не добавлены в строку
+		pendingRows  int // Пустые строки, которые ещё не добавлены в лист
+	)
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("xml.Token failed: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "style":
+				styleName = odsAttr(t, "name")
+			case "table-properties":
+				if odsAttr(t, "display") == "false" {
+					hiddenStyles[styleName] = true
+				}
+			case "table":
+				if len(tables) >= l.MaxSheets {
+					return nil, &LimitError{Err: ErrTooManySheets, Limit: int64(l.MaxSheets), Actual: int64(len(tables) + 1)}
+				}
+				tables = append(tables, sheet{sheetInfo: sheetInfo{
+					name:   odsAttr(t, "name"),
+					hidden: hiddenStyles[odsAttr(t, "style-name")],
+				}})
+				table = &tables[len(tables)-1]
+				limiter = newRowLimiter(l)
+				pendingRows = 0
+			case "table-row":
+				row = make([]string, 0, MAX_LEN_OF_ROW)
+				rowRepeat = odsRepeat(t, "number-rows-repeated", l.MaxRows+1)
+				pendingCells = 0
+			case "table-cell", "covered-table-cell":
+				repeat := odsRepeat(t, "number-columns-repeated", ODS_MAX_COLUMNS)
+
+				value, err := odsCellValue(d, t, l.MaxLenOfCell)
+				if err != nil {
+					return nil, err
+				}
+
+				if value == "" {
+					if pendingCells < ODS_MAX_COLUMNS {
+						pendingCells += repeat
+					}
+					continue
+				}
+
+				for i := 0; i < pendingCells && len(row) < ODS_MAX_COLUMNS; i++ {
+					row = append(row, "")
+				}
+				pendingCells = 0
+				for i := 0; i < repeat && len(row) < ODS_MAX_COLUMNS; i++ {
+					row = append(row, value)
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local != "table-row" || table == nil {
+				continue
+			}
+
+			if len(row) == 0 {
+				if pendingRows <= l.MaxRows {
+					pendingRows += rowRepeat
+				}
+				continue
+			}
+
+			// Повторённые строки одинаковые, достаточно проверить последнюю из них
+			if err := limiter.check(len(table.rows)+pendingRows+rowRepeat, row); err != nil {
+				return nil, err
+			}
+			for i := 0; i < pendingRows; i++ {
+				table.rows = append(table.rows, nil)
+			}
+			pendingRows = 0
+			for i := 0; i < rowRepeat; i++ {
+				table.rows = append(table.rows, row)
+			}
+		}
+	}
+
+	return tables, nil
+}
+
+// odsCellValue читает содержимое ячейки до её закрывающего тега. Для чисел берётся office:value, т.к. в тексте
+// ячейки число записано с учётом локали ("45,5"), для дат - office:date-value в ISO 8601. Примечания к ячейке
+// (office:annotation) пропускаются. Текст ячейки длиннее maxLen символов не собирается целиком: один text:s
+// разворачивается в сотни пробелов, и маленький content.xml мог бы дать огромную строку.
+func odsCellValue(d *xml.Decoder, start xml.StartElement, maxLen int) (string, error) {
+	valueType := odsAttr(start, "value-type")
+	rawValue := odsAttr(start, "value")
+	if valueType == "date" {
+		rawValue = odsAttr(start, "date-value")
+	}
+
+	var b strings.Builder
+	paragraphs := 0
+	inParagraph := 0
+
+	// В символе не больше utf8.UTFMax байт, точную длину в символах проверит rowLimiter
+	maxBytes := maxLen * utf8.UTFMax
+	tooLong := func(extra int) error {
+		return &LimitError{Err: ErrCellTooLong, Limit: int64(maxLen),
+			Actual: int64(utf8.RuneCountInString(b.String()) + extra)}
+	}
+
+	for {
+		if b.Len() > maxBytes {
+			return "", tooLong(0)
+		}
+
+		tok, err := d.Token()
+		if err != nil {
+			return "", fmt.Errorf("xml.Token failed: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "annotation":
+				if err := d.Skip(); err != nil {
+					return "", fmt.Errorf("d.Skip failed: %w", err)
+				}
+			case "p":
+				if paragraphs > 0 {
+					b.WriteString("\n")
+				}
+				paragraphs++
+				inParagraph++
+			case "s":
+				n := odsRepeat(t, "c", ODS_MAX_COLUMNS)
+				if b.Len()+n > maxBytes {
+					return "", tooLong(n)
+				}
+				b.WriteString(strings.Repeat(" ", n))
+			case "tab":
+				b.WriteString("\t")
+			case "line-break":
+				b.WriteString("\n")
+			}
+		case xml.CharData:
+			// Пробелы и переводы строк между тегами - не текст ячейки
+			if inParagraph > 0 {
+				b.Write(t)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "p" {
+				inParagraph--
+				continue
+			}
+			if t.Name.Local != start.Name.Local {
+				continue
+			}
+
+			if rawValue != "" && (valueType == "float" || valueType == "percentage" || valueType == "currency" ||
+				valueType == "date") {
+				return rawValue, nil
+			}
+			return b.String(), nil
+		}
+	}
+}
+
+func odsAttr(t xml.StartElement, local string) string {
+	for _, a := range t.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// odsRepeat читает атрибут-счётчик повторов. Значение ограничивается сверху max, чтобы не разворачивать
+// заведомо лишние строки и ячейки.
+func odsRepeat(t xml.StartElement, local string, max int) int {
+	n, err := strconv.Atoi(odsAttr(t, local))
+	if err != nil || n < 1 {
+		return 1
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
@@ -0,0 +1,33 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func odsContent(cell string) string {
+	return `<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" ` +
+		`xmlns:table="urn:oasis:names:tc:opendocument:xmlns:table:1.0" ` +
+		`xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0"><office:body><office:spreadsheet>` +
+		`<table:table table:name="Заявка"><table:table-row><table:table-cell><text:p>` + cell +
+		`</text:p></table:table-cell></table:table-row></table:table></office:spreadsheet></office:body>` +
+		`</office:document-content>`
+}
+
+// text:s разворачивается в пробелы, и маленький content.xml не должен превращаться в огромную ячейку.
+func TestDecodeOdsContentCellLimit(t *testing.T) {
+	l := DefaultLimits()
+
+	wb, err := decodeOdsContent(strings.NewReader(odsContent(`a<text:s text:c="3"/>b`)), l)
+	if err != nil || len(wb) != 1 || wb[0].rows[0][0] != "a   b" {
+		t.Fatalf("decodeOdsContent = %+v, %v", wb, err)
+	}
+
+	spaces := strings.Repeat(`<text:s text:c="1024"/>`, 10000)
+	_, err = decodeOdsContent(strings.NewReader(odsContent(spaces)), l)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || !errors.Is(err, ErrCellTooLong) {
+		t.Fatalf("err = %v, want ErrCellTooLong", err)
+	}
+}
@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xuri/excelize/v2"
+)
+
+// Коды ошибок, которыми помечаются некорректные строки заявки.
+const (
+	ERR_CODE_BAD_FULLNAME        = "BAD_FULLNAME"
+	ERR_CODE_BAD_SEX             = "BAD_SEX"
+	ERR_CODE_BAD_AGE             = "BAD_AGE"
+	ERR_CODE_BAD_BIRTH_DATE      = "BAD_BIRTH_DATE"
+	ERR_CODE_BAD_KYI             = "BAD_KYI"
+	ERR_CODE_BAD_DAN             = "BAD_DAN"
+	ERR_CODE_BAD_WEIGHT          = "BAD_WEIGHT"
+	ERR_CODE_BAD_CATEGORY        = "BAD_CATEGORY"
+	ERR_CODE_UNKNOWN_KATA_KUMITE = "UNKNOWN_KATA_KUMITE"
+	ERR_CODE_BAD_ROW             = "BAD_ROW"
+	ERR_CODE_BAD_META            = "BAD_META"
+	ERR_CODE_DUPLICATE           = "DUPLICATE"
+	ERR_CODE_BAD_TEAM            = "BAD_TEAM"
+	ERR_CODE_NO_CATEGORY         = "NO_CATEGORY" // Для возраста, пола или кю участника нет категории
+	ERR_CODE_NOT_SAVED           = "NOT_SAVED"   // Строка верна, но участника не удалось записать в БД
+)
+
+var (
+	ErrNoParticipants = errors.New("В заявке не найдено ни одного участника")
+)
+
+// percentOf возвращает долю part от total в процентах. Для пустой заявки (total = 0) доля ошибок равна 0.
+func percentOf(part, total int) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
+
+// RowError описывает одну найденную в строке заявки ошибку. Row - номер строки на листе в нумерации Excel (с 1),
+// Column - буква столбца, Value - исходное значение ячейки.
+type RowError struct {
+	Row     int    `json:"row"`
+	Column  string `json:"column"`
+	Value   string `json:"value"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("строка %d, столбец %s: %s", e.Row, e.Column, e.Message)
+}
+
+// cellError возвращается конвертерами строк и указывает на ячейку, которую не удалось распознать.
+// Номер строки конвертеры не знают, его дописывает karateParser при сборке RowError.
+type cellError struct {
+	col     int
+	code    string
+	message string
+}
+
+func (e *cellError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.col, e.code)
+}
+
+func newCellError(col int, code, message string) *cellError {
+	return &cellError{col: col, code: code, message: message}
+}
+
+// toRowError переводит ошибку конвертера в RowError. rowIdx - индекс строки в срезе rows (с 0).
+func toRowError(rowIdx int, row []string, e *cellError) RowError {
+	// Столбец может отсутствовать в файле (col = -1), тогда ошибка относится ко всей строке
+	column, value := "", ""
+	if e.col >= 0 {
+		name, err := excelize.ColumnNumberToName(e.col + 1)
+		if err == nil {
+			column = name
+		}
+		if e.col < len(row) {
+			value = row[e.col]
+		}
+	}
+
+	return RowError{
+		Row:     rowIdx + 1,
+		Column:  column,
+		Value:   value,
+		Code:    e.code,
+		Message: e.message,
+	}
+}
@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrSheetNotFound = errors.New("В файле не найден лист с заявкой")
+)
+
+type sheetInfo struct {
+	name   string
+	hidden bool
+}
+
+// workbook - прочитанный файл заявки любого формата. rows возвращает не больше limit строк листа
+// (limit = 0 - все строки), этого хватает, чтобы найти строку заголовков, не читая лист целиком.
+type workbook interface {
+	sheets() []sheetInfo
+	rows(name string, limit int) ([][]string, error)
+}
+
+type sheet struct {
+	sheetInfo
+	rows [][]string
+}
+
+// memWorkbook - книга, уже полностью прочитанная в память (csv, ods).
+type memWorkbook []sheet
+
+func (w memWorkbook) sheets() []sheetInfo {
+	list := make([]sheetInfo, len(w))
+	for i, s := range w {
+		list[i] = s.sheetInfo
+	}
+	return list
+}
+
+func (w memWorkbook) rows(name string, limit int) ([][]string, error) {
+	for _, s := range w {
+		if s.name != name {
+			continue
+		}
+		if limit > 0 && len(s.rows) > limit {
+			return s.rows[:limit], nil
+		}
+		return s.rows, nil
+	}
+	return nil, fmt.Errorf("sheet %q: %w", name, ErrSheetNotFound)
+}
+
+// selectSheet выбирает лист с заявкой: лист с настроенным именем, если он есть; иначе первый видимый лист, в котором
+// нашлась строка заголовков заявки по каратэ; иначе просто первый видимый лист.
+func selectSheet(wb workbook, preferred string) (string, error) {
+	list := wb.sheets()
+
+	for _, s := range list {
+		if s.name == preferred {
+			return s.name, nil
+		}
+	}
+
+	firstVisible := ""
+	for _, s := range list {
+		if s.hidden {
+			continue
+		}
+		if firstVisible == "" {
+			firstVisible = s.name
+		}
+
+		head, err := wb.rows(s.name, MAX_HEADER_SEARCH_ROWS)
+		if err != nil {
+			return "", fmt.Errorf("wb.rows failed: %w", err)
+		}
+		if _, _, err := findHeader(head); err == nil {
+			return s.name, nil
+		}
+	}
+
+	if firstVisible == "" {
+		return "", ErrSheetNotFound
+	}
+
+	return firstVisible, nil
+}
@@ -0,0 +1,285 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/Geniuskaa/micro_registration/internal/sports/karate"
+	"github.com/jackc/pgtype"
+	"github.com/xuri/excelize/v2"
+	"math"
+	"sort"
+)
+
+const (
+	CATEGORIES_SHEET_NAME  = "Категории"
+	INSTRUCTION_SHEET_NAME = "Инструкция"
+
+	// Кол-во строк под участников, на которые распространяются выпадающие списки шаблона
+	TEMPLATE_PARTICIPANT_ROWS = 300
+	TEMPLATE_COLUMN_WIDTH     = 16
+
+	XLSX_CONTENT_TYPE = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+var templateInstruction = []string{
+	"Как заполнить заявку",
+	"",
+	"1. Заполните контакты клуба в шапке листа \"" + SHEET_NAME + "\". UUID и дату соревнований не меняйте.",
+	"2. Каждый участник - отдельная строка под заголовками. Порядок столбцов можно менять, названия - нет.",
+	"3. ФИО - фамилия, имя и отчество (если есть) через пробел.",
+	"4. Пол, дисциплину и участие в групповом ката выберите из выпадающего списка.",
+	"5. Укажите дату рождения или полных лет. Если указана дата рождения, возраст посчитаем на день соревнований.",
+	"6. Кю и дан - целые числа от 0 до 10, можно римскими цифрами.",
+	"7. Для кумите обязательны вес в килограммах и весовая категория. Категории для каждого возраста и пола " +
+		"перечислены на листе \"" + CATEGORIES_SHEET_NAME + "\".",
+	"8. Команда ката - одинаковое название у трёх участников одной возрастной категории.",
+	"9. Отправьте заполненный файл на адрес, с которого пришёл шаблон, указав в теме письма " +
+		"\"Соревнования ДД.ММ.ГГГГ\".",
+}
+
+// templateColumn - проверка значений столбца шаблона. Если list пуст, а source задан, значения берутся
+// из диапазона на другом листе.
+type templateColumn struct {
+	list     []string
+	source   string
+	min, max int
+	strict   bool // Не пускать в ячейку значение не из списка. Иначе Excel только предупредит
+	message  string
+}
+
+// KarateTemplate собирает xlsx-шаблон заявки на соревнование: шапка с UUID и датой соревнований, заголовки
+// столбцов, выпадающие списки, справочник весовых категорий и инструкция. Заполненный шаблон читается ParseXlsx.
+func KarateTemplate(c karate.Competition, categories []karate.KumiteCategories) ([]byte, error) {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	f.SetSheetName(f.GetSheetName(0), SHEET_NAME)
+
+	meta := [][]interface{}{
+		{"UUID", c.UUID},
+		{"Дата соревнований", c.Date.Format("02.01.2006")},
+		{"Клуб", ""},
+		{"Город клуба", ""},
+		{"Email", ""},
+		{"Телефон", ""},
+		{"Дата подачи заявки", ""},
+	}
+	for i, row := range meta {
+		row := row
+		if err := f.SetSheetRow(SHEET_NAME, fmt.Sprintf("A%d", i+1), &row); err != nil {
+			return nil, fmt.Errorf("f.SetSheetRow failed: %w", err)
+		}
+	}
+
+	headerRow := len(meta) + 2
+	header := make([]interface{}, 0, len(karateColumns))
+	for _, c := range karateColumns {
+		header = append(header, c.title)
+	}
+	if err := f.SetSheetRow(SHEET_NAME, fmt.Sprintf("A%d", headerRow), &header); err != nil {
+		return nil, fmt.Errorf("f.SetSheetRow failed: %w", err)
+	}
+
+	if err := templateStyles(f, headerRow); err != nil {
+		return nil, err
+	}
+
+	weights, err := templateCategoriesSheet(f, categories)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]templateColumn{
+		COL_SEX: {list: []string{karate.SEX_MALE, karate.SEX_FEMALE}, strict: true,
+			message: "Выберите \"м\" или \"ж\""},
+		COL_KATA_KUMITE: {list: []string{KARATE_KATA, KARATE_KUMITE, KARATE_KATA + "/" + KARATE_KUMITE}, strict: true,
+			message: "Выберите \"кат\", \"кум\" или \"кат/кум\""},
+		COL_KATA_GROUP: {list: []string{"да", "нет"}, strict: true,
+			message: "Выберите \"да\" или \"нет\""},
+		COL_AGE: {min: 0, max: MAX_AGE, message: fmt.Sprintf("Возраст - целое число от 0 до %d", MAX_AGE)},
+		COL_KYI: {min: 0, max: MAX_GRADE, message: fmt.Sprintf("Кю - целое число от 0 до %d", MAX_GRADE)},
+		COL_DAN: {min: 0, max: MAX_GRADE, message: fmt.Sprintf("Дан - целое число от 0 до %d", MAX_GRADE)},
+	}
+	if weights > 0 {
+		columns[COL_CATEGORY] = templateColumn{
+			source: fmt.Sprintf("'%s'!$A$2:$A$%d", CATEGORIES_SHEET_NAME, weights+1),
+			message: "Выберите категорию из списка, подходящие для возраста и пола - на листе \"" +
+				CATEGORIES_SHEET_NAME + "\"",
+		}
+	}
+
+	for i, c := range karateColumns {
+		col, ok := columns[c.key]
+		if !ok {
+			continue
+		}
+		name, err := excelize.ColumnNumberToName(i + 1)
+		if err != nil {
+			return nil, fmt.Errorf("excelize.ColumnNumberToName failed: %w", err)
+		}
+		sqref := fmt.Sprintf("%s%d:%s%d", name, headerRow+1, name, headerRow+TEMPLATE_PARTICIPANT_ROWS)
+		if err := addTemplateValidation(f, c.title, sqref, col); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := templateInstructionSheet(f); err != nil {
+		return nil, err
+	}
+
+	f.SetActiveSheet(f.GetSheetIndex(SHEET_NAME))
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		return nil, fmt.Errorf("f.WriteToBuffer failed: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// TemplateFilename - имя файла шаблона заявки на соревнование.
+func TemplateFilename(c karate.Competition) string {
+	return fmt.Sprintf("Заявка %s.xlsx", c.Date.Format("02.01.2006"))
+}
+
+func addTemplateValidation(f *excelize.File, title, sqref string, col templateColumn) error {
+	dv := excelize.NewDataValidation(true)
+	dv.SetSqref(sqref)
+
+	var err error
+	switch {
+	case len(col.list) != 0:
+		err = dv.SetDropList(col.list)
+	case col.source != "":
+		dv.SetSqrefDropList(col.source)
+	default:
+		err = dv.SetRange(col.min, col.max, excelize.DataValidationTypeWhole, excelize.DataValidationOperatorBetween)
+	}
+	if err != nil {
+		return fmt.Errorf("dv.SetDropList failed: %w", err)
+	}
+
+	style := excelize.DataValidationErrorStyleWarning
+	if col.strict {
+		style = excelize.DataValidationErrorStyleStop
+	}
+	dv.SetError(style, title, col.message)
+
+	if err := f.AddDataValidation(SHEET_NAME, dv); err != nil {
+		return fmt.Errorf("f.AddDataValidation failed: %w", err)
+	}
+	return nil
+}
+
+// templateStyles выделяет заголовки, закрепляет шапку и расширяет столбцы, чтобы заголовки были видны целиком.
+func templateStyles(f *excelize.File, headerRow int) error {
+	last, err := excelize.ColumnNumberToName(len(karateColumns))
+	if err != nil {
+		return fmt.Errorf("excelize.ColumnNumberToName failed: %w", err)
+	}
+
+	if err := f.SetColWidth(SHEET_NAME, "A", last, TEMPLATE_COLUMN_WIDTH); err != nil {
+		return fmt.Errorf("f.SetColWidth failed: %w", err)
+	}
+	// ФИО заметно длиннее остальных значений
+	if err := f.SetColWidth(SHEET_NAME, "A", "A", TEMPLATE_COLUMN_WIDTH*2); err != nil {
+		return fmt.Errorf("f.SetColWidth failed: %w", err)
+	}
+
+	style, err := f.NewStyle(&excelize.Style{
+		Font:      &excelize.Font{Bold: true},
+		Fill:      excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#D9E1F2"}},
+		Alignment: &excelize.Alignment{WrapText: true, Vertical: "center"},
+	})
+	if err != nil {
+		return fmt.Errorf("f.NewStyle failed: %w", err)
+	}
+	if err := f.SetCellStyle(SHEET_NAME, fmt.Sprintf("A%d", headerRow), fmt.Sprintf("%s%d", last, headerRow), style); err != nil {
+		return fmt.Errorf("f.SetCellStyle failed: %w", err)
+	}
+
+	panes := fmt.Sprintf(`{"freeze":true,"split":false,"x_split":0,"y_split":%d,"top_left_cell":"A%d","active_pane":"bottomLeft"}`,
+		headerRow, headerRow+1)
+	if err := f.SetPanes(SHEET_NAME, panes); err != nil {
+		return fmt.Errorf("f.SetPanes failed: %w", err)
+	}
+	return nil
+}
+
+// templateCategoriesSheet записывает справочник весовых категорий: в столбце A - все категории для выпадающего
+// списка, правее - категории для каждого возраста и пола. Возвращает кол-во категорий в столбце A.
+func templateCategoriesSheet(f *excelize.File, categories []karate.KumiteCategories) (int, error) {
+	f.NewSheet(CATEGORIES_SHEET_NAME)
+
+	rows := [][]interface{}{{"Категория", "", "Возраст", "Пол", "Весовые категории"}}
+
+	unique := make(map[string]pgtype.Int4range)
+	for _, c := range categories {
+		row := []interface{}{"", "", karate.FormatAgeCategory(c.Age), c.Sex}
+		for _, w := range c.Weights {
+			name := karate.FormatWeightCategory(w)
+			unique[name] = w
+			row = append(row, name)
+		}
+		rows = append(rows, row)
+	}
+
+	// Категории в выпадающем списке идут по возрастанию веса: "-35", "35-40", "40-45", ..., "60+"
+	all := make([]string, 0, len(unique))
+	for name := range unique {
+		all = append(all, name)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		a, b := unique[all[i]], unique[all[j]]
+		if upperOf(a) != upperOf(b) {
+			return upperOf(a) < upperOf(b)
+		}
+		if lowerOf(a) != lowerOf(b) {
+			return lowerOf(a) < lowerOf(b)
+		}
+		return all[i] < all[j]
+	})
+
+	for i, name := range all {
+		if i+1 >= len(rows) {
+			rows = append(rows, []interface{}{""})
+		}
+		rows[i+1][0] = name
+	}
+
+	for i, row := range rows {
+		row := row
+		if err := f.SetSheetRow(CATEGORIES_SHEET_NAME, fmt.Sprintf("A%d", i+1), &row); err != nil {
+			return 0, fmt.Errorf("f.SetSheetRow failed: %w", err)
+		}
+	}
+
+	return len(all), nil
+}
+
+// upperOf и lowerOf нужны для сортировки категорий: отсутствующая граница - бесконечность.
+func upperOf(r pgtype.Int4range) int64 {
+	if r.UpperType == pgtype.Unbounded {
+		return math.MaxInt64
+	}
+	return int64(r.Upper.Int)
+}
+
+func lowerOf(r pgtype.Int4range) int64 {
+	if r.LowerType == pgtype.Unbounded {
+		return math.MinInt64
+	}
+	return int64(r.Lower.Int)
+}
+
+func templateInstructionSheet(f *excelize.File) error {
+	f.NewSheet(INSTRUCTION_SHEET_NAME)
+
+	for i, line := range templateInstruction {
+		if err := f.SetCellStr(INSTRUCTION_SHEET_NAME, fmt.Sprintf("A%d", i+1), line); err != nil {
+			return fmt.Errorf("f.SetCellStr failed: %w", err)
+		}
+	}
+	if err := f.SetColWidth(INSTRUCTION_SHEET_NAME, "A", "A", 120); err != nil {
+		return fmt.Errorf("f.SetColWidth failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package parser
+
+import (
+	"bytes"
+	"github.com/Geniuskaa/micro_registration/internal/sports/karate"
+	"github.com/jackc/pgtype"
+	"github.com/xuri/excelize/v2"
+	"testing"
+	"time"
+)
+
+func int4range(t *testing.T, s string) pgtype.Int4range {
+	t.Helper()
+	r := pgtype.Int4range{}
+	if err := r.DecodeText(nil, []byte(s)); err != nil {
+		t.Fatalf("r.DecodeText(%q) failed: %v", s, err)
+	}
+	return r
+}
+
+// Заполненный шаблон должен разбираться парсером без правок: UUID из шапки, заголовки на своих местах.
+func TestKarateTemplateRoundTrip(t *testing.T) {
+	competition := karate.Competition{UUID: testUUID, Date: time.Date(2024, time.May, 18, 0, 0, 0, 0, time.UTC)}
+	categories := []karate.KumiteCategories{
+		{Age: int4range(t, "[12,14)"), Sex: karate.SEX_MALE,
+			Weights: []pgtype.Int4range{int4range(t, "(,36)"), int4range(t, "[35,41)"), int4range(t, "[60,)")}},
+		{Age: int4range(t, "[18,)"), Sex: karate.SEX_FEMALE,
+			Weights: []pgtype.Int4range{int4range(t, "(,56)"), int4range(t, "[35,41)")}},
+	}
+
+	data, err := KarateTemplate(competition, categories)
+	if err != nil {
+		t.Fatalf("KarateTemplate failed: %v", err)
+	}
+
+	f, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("excelize.OpenReader failed: %v", err)
+	}
+	defer f.Close()
+
+	list, err := f.GetCols(CATEGORIES_SHEET_NAME)
+	if err != nil {
+		t.Fatalf("f.GetCols failed: %v", err)
+	}
+	want := []string{"Категория", "-35", "35-40", "-55", "60+"}
+	if len(list) == 0 || len(list[0]) != len(want) {
+		t.Fatalf("drop-down list of categories = %v, want %v", list, want)
+	}
+	for i := range want {
+		if list[0][i] != want[i] {
+			t.Fatalf("drop-down list of categories = %v, want %v", list[0], want)
+		}
+	}
+
+	_, cols, err := findHeader(mustRows(t, f))
+	if err != nil {
+		t.Fatalf("findHeader failed: %v", err)
+	}
+	row := map[string]string{
+		COL_FULLNAME:    "Иванов Иван",
+		COL_SEX:         "м",
+		COL_AGE:         "12",
+		COL_KYI:         "8",
+		COL_KATA_KUMITE: "кат/кум",
+		COL_WEIGHT:      "38",
+		COL_CATEGORY:    "35-40",
+	}
+	for key, value := range row {
+		cell, err := excelize.CoordinatesToCellName(cols[key]+1, 10)
+		if err != nil {
+			t.Fatalf("excelize.CoordinatesToCellName failed: %v", err)
+		}
+		if err := f.SetCellStr(SHEET_NAME, cell, value); err != nil {
+			t.Fatalf("f.SetCellStr failed: %v", err)
+		}
+	}
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatalf("f.WriteToBuffer failed: %v", err)
+	}
+
+	resp, err := Impl{}.ParseXlsx(buf)
+	if err != nil {
+		t.Fatalf("ParseXlsx failed: %v", err)
+	}
+	if resp.UUID != testUUID {
+		t.Errorf("UUID = %q, want %q", resp.UUID, testUUID)
+	}
+	if resp.TotalRows != 1 || resp.InvalidRows != 0 || len(resp.Map) != 1 {
+		t.Fatalf("total %d, invalid %d, participants %d, errors %v", resp.TotalRows, resp.InvalidRows, len(resp.Map),
+			resp.RowErrors)
+	}
+	for _, v := range resp.Map {
+		p := v.(karate.Participant)
+		if p.Row != 10 || karate.FormatWeightCategory(p.Category) != "35-40" {
+			t.Errorf("participant %+v", p)
+		}
+	}
+}
+
+func mustRows(t *testing.T, f *excelize.File) [][]string {
+	t.Helper()
+	rows, err := f.GetRows(SHEET_NAME)
+	if err != nil {
+		t.Fatalf("f.GetRows failed: %v", err)
+	}
+	return rows
+}
@@ -0,0 +1,560 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/richardlehane/mscfb"
+	"io"
+	"math"
+	"strconv"
+	"unicode/utf16"
+)
+
+// Типы записей BIFF8, которые нужны для чтения значений ячеек. Остальные записи (стили, форматы, формулы без
+// кэшированного значения и т.д.) пропускаются.
+const (
+	xlsRecordBOF        = 0x0809
+	xlsRecordEOF        = 0x000A
+	xlsRecordBoundSheet = 0x0085
+	xlsRecordSST        = 0x00FC
+	xlsRecordContinue   = 0x003C
+	xlsRecordFilePass   = 0x002F
+	xlsRecordLabelSST   = 0x00FD
+	xlsRecordLabel      = 0x0204
+	xlsRecordRString    = 0x00D6
+	xlsRecordNumber     = 0x0203
+	xlsRecordRK         = 0x027E
+	xlsRecordMulRK      = 0x00BD
+	xlsRecordFormula    = 0x0006
+	xlsRecordString     = 0x0207
+	xlsRecordBoolErr    = 0x0205
+	xlsRecordShrFmla    = 0x04BC
+	xlsRecordArray      = 0x0221
+
+	xlsBIFF8Version = 0x0600
+
+	// Книга Excel 97-2003 не может содержать больше 256 столбцов
+	XLS_MAX_COLUMNS = 256
+)
+
+var (
+	ErrXlsEncrypted   = errors.New("Файл .xls защищён паролем, снимите защиту и отправьте заявку повторно")
+	ErrXlsUnsupported = errors.New("Файл .xls сохранён в устаревшей версии Excel (до 97), пересохраните его в формате .xlsx")
+
+	cfbMagic = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+	errXlsWorkbookNotFound = errors.New("Workbook stream not found")
+	errXlsTruncated        = errors.New("unexpected end of BIFF record")
+)
+
+type xlsSheet struct {
+	sheetInfo
+	offset int // Смещение записи BOF листа в потоке Workbook
+}
+
+type xlsRecord struct {
+	typ  uint16
+	data []byte
+}
+
+// xlsWorkbook - книга Excel 97-2003 (BIFF8). Глобальные записи (список листов и SST) читаются сразу, а ячейки
+// листа - только когда лист понадобится. Записи BOUNDSHEET разных листов могут указывать на один и тот же лист,
+// поэтому прочитанные листы хранятся по смещению и не читаются повторно.
+type xlsWorkbook struct {
+	stream  []byte
+	list    []xlsSheet
+	sst     []string
+	limits  Limits
+	decoded map[int][][]string
+}
+
+// readXls читает глобальные записи книги Excel 97-2003 (BIFF8).
+func readXls(data []byte, l Limits) (*xlsWorkbook, error) {
+	stream, err := xlsWorkbookStream(data)
+	if err != nil {
+		return nil, err
+	}
+
+	list, sst, err := xlsGlobals(stream, l.MaxSheets)
+	if err != nil {
+		return nil, err
+	}
+
+	return &xlsWorkbook{stream: stream, list: list, sst: sst, limits: l, decoded: make(map[int][][]string)}, nil
+}
+
+func (w *xlsWorkbook) sheets() []sheetInfo {
+	list := make([]sheetInfo, len(w.list))
+	for i, s := range w.list {
+		list[i] = s.sheetInfo
+	}
+	return list
+}
+
+func (w *xlsWorkbook) rows(name string, limit int) ([][]string, error) {
+	for _, s := range w.list {
+		if s.name != name {
+			continue
+		}
+
+		rows, ok := w.decoded[s.offset]
+		if !ok {
+			var err error
+			rows, err = xlsSheetRows(w.stream, s.offset, w.sst, w.limits.MaxRows)
+			if err != nil {
+				return nil, fmt.Errorf("xlsSheetRows failed for sheet %q: %w", s.name, err)
+			}
+			if err := checkRows(rows, w.limits); err != nil {
+				return nil, err
+			}
+			w.decoded[s.offset] = rows
+		}
+
+		if limit > 0 && len(rows) > limit {
+			return rows[:limit], nil
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("sheet %q: %w", name, ErrSheetNotFound)
+}
+
+// xlsWorkbookStream достаёт поток Workbook из составного документа (Compound File Binary).
+func xlsWorkbookStream(data []byte) ([]byte, error) {
+	doc, err := mscfb.New(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("mscfb.New failed: %w", err)
+	}
+
+	for _, f := range doc.File {
+		switch f.Name {
+		case "Workbook":
+			stream, err := io.ReadAll(io.LimitReader(f, int64(len(data))))
+			if err != nil {
+				return nil, fmt.Errorf("io.ReadAll failed: %w", err)
+			}
+			return stream, nil
+		case "Book":
+			// Поток "Book" пишут версии Excel до 97 (BIFF5), их строки хранятся в кодировке системы
+			return nil, ErrXlsUnsupported
+		}
+	}
+
+	return nil, fmt.Errorf("xlsWorkbookStream failed: %w", errXlsWorkbookNotFound)
+}
+
+// nextXlsRecord читает запись, начинающуюся со смещения pos. Возвращает смещение следующей записи.
+func nextXlsRecord(stream []byte, pos int) (xlsRecord, int, error) {
+	if pos+4 > len(stream) {
+		return xlsRecord{}, pos, io.EOF
+	}
+
+	typ := binary.LittleEndian.Uint16(stream[pos:])
+	size := int(binary.LittleEndian.Uint16(stream[pos+2:]))
+	end := pos + 4 + size
+	if end > len(stream) {
+		return xlsRecord{}, pos, errXlsTruncated
+	}
+
+	return xlsRecord{typ: typ, data: stream[pos+4 : end]}, end, nil
+}
+
+// xlsGlobals читает блок глобальных записей книги: список листов и таблицу общих строк (SST). Листов может быть
+// не больше maxSheets.
+func xlsGlobals(stream []byte, maxSheets int) ([]xlsSheet, []string, error) {
+	sheets := make([]xlsSheet, 0, 1)
+	var sst []string
+
+	rec, pos, err := nextXlsRecord(stream, 0)
+	if err != nil || rec.typ != xlsRecordBOF || len(rec.data) < 2 {
+		return nil, nil, ErrXlsUnsupported
+	}
+	if binary.LittleEndian.Uint16(rec.data) != xlsBIFF8Version {
+		return nil, nil, ErrXlsUnsupported
+	}
+
+	for {
+		rec, pos, err = nextXlsRecord(stream, pos)
+		if err != nil {
+			return nil, nil, fmt.Errorf("nextXlsRecord failed: %w", err)
+		}
+
+		switch rec.typ {
+		case xlsRecordEOF:
+			return sheets, sst, nil
+		case xlsRecordFilePass:
+			return nil, nil, ErrXlsEncrypted
+		case xlsRecordBoundSheet:
+			if len(rec.data) < 8 {
+				return nil, nil, errXlsTruncated
+			}
+			// Нас интересуют только обычные листы, листы диаграмм и макросов пропускаем
+			if rec.data[5] != 0 {
+				continue
+			}
+			if len(sheets) >= maxSheets {
+				return nil, nil, &LimitError{Err: ErrTooManySheets, Limit: int64(maxSheets), Actual: int64(len(sheets) + 1)}
+			}
+			name, _, err := xlsString(rec.data[6:], 1)
+			if err != nil {
+				return nil, nil, err
+			}
+			sheets = append(sheets, xlsSheet{
+				sheetInfo: sheetInfo{name: name, hidden: rec.data[4]&0x03 != 0},
+				offset:    int(binary.LittleEndian.Uint32(rec.data)),
+			})
+		case xlsRecordSST:
+			segments := [][]byte{rec.data}
+			for {
+				next, nextPos, err := nextXlsRecord(stream, pos)
+				if err != nil || next.typ != xlsRecordContinue {
+					break
+				}
+				segments = append(segments, next.data)
+				pos = nextPos
+			}
+
+			sst, err = readSST(segments)
+			if err != nil {
+				return nil, nil, fmt.Errorf("readSST failed: %w", err)
+			}
+		}
+	}
+}
+
+// xlsSheetRows читает значения ячеек листа, начиная с его записи BOF и до EOF. Ячейки в записях идут
+// в произвольном порядке, поэтому ограничение на кол-во строк проверяется по номеру строки ячейки.
+func xlsSheetRows(stream []byte, offset int, sst []string, maxRows int) ([][]string, error) {
+	rec, pos, err := nextXlsRecord(stream, offset)
+	if err != nil || rec.typ != xlsRecordBOF {
+		return nil, errXlsTruncated
+	}
+
+	rows := make([][]string, 0)
+	set := func(row, col int, value string) error {
+		if col >= XLS_MAX_COLUMNS || value == "" {
+			return nil
+		}
+		if row >= maxRows {
+			return &LimitError{Err: ErrTooManyRows, Limit: int64(maxRows), Actual: int64(row + 1)}
+		}
+		for len(rows) <= row {
+			rows = append(rows, nil)
+		}
+		for len(rows[row]) <= col {
+			rows[row] = append(rows[row], "")
+		}
+		rows[row][col] = value
+		return nil
+	}
+
+	// Значение строковой формулы лежит в следующей за FORMULA записи STRING
+	formulaRow, formulaCol := -1, -1
+	// Внутри листа могут быть вложенные блоки BOF..EOF (например, диаграммы), их записи пропускаем
+	depth := 1
+
+	for {
+		rec, pos, err = nextXlsRecord(stream, pos)
+		if err != nil {
+			return nil, fmt.Errorf("nextXlsRecord failed: %w", err)
+		}
+		switch rec.typ {
+		case xlsRecordBOF:
+			depth++
+			continue
+		case xlsRecordEOF:
+			depth--
+			if depth == 0 {
+				return rows, nil
+			}
+			continue
+		}
+		if depth > 1 {
+			continue
+		}
+
+		switch rec.typ {
+		case xlsRecordString:
+			if formulaRow >= 0 {
+				s, _, err := xlsString(rec.data, 2)
+				if err != nil {
+					return nil, err
+				}
+				if err := set(formulaRow, formulaCol, s); err != nil {
+					return nil, err
+				}
+			}
+			formulaRow, formulaCol = -1, -1
+			continue
+		case xlsRecordFormula:
+		default:
+			// Между FORMULA и STRING могут стоять только записи общей формулы и формулы массива
+			if rec.typ != xlsRecordShrFmla && rec.typ != xlsRecordArray {
+				formulaRow, formulaCol = -1, -1
+			}
+		}
+
+		if len(rec.data) < 6 {
+			continue
+		}
+		row := int(binary.LittleEndian.Uint16(rec.data))
+		col := int(binary.LittleEndian.Uint16(rec.data[2:]))
+
+		switch rec.typ {
+		case xlsRecordLabelSST:
+			if len(rec.data) < 10 {
+				return nil, errXlsTruncated
+			}
+			idx := int(binary.LittleEndian.Uint32(rec.data[6:]))
+			if idx < len(sst) {
+				err = set(row, col, sst[idx])
+			}
+		case xlsRecordLabel, xlsRecordRString:
+			var s string
+			s, _, err = xlsString(rec.data[6:], 2)
+			if err == nil {
+				err = set(row, col, s)
+			}
+		case xlsRecordNumber:
+			if len(rec.data) < 14 {
+				return nil, errXlsTruncated
+			}
+			err = set(row, col, formatXlsNumber(math.Float64frombits(binary.LittleEndian.Uint64(rec.data[6:]))))
+		case xlsRecordRK:
+			if len(rec.data) < 10 {
+				return nil, errXlsTruncated
+			}
+			err = set(row, col, formatXlsNumber(rkValue(binary.LittleEndian.Uint32(rec.data[6:]))))
+		case xlsRecordMulRK:
+			// colFirst, затем пары (ixfe, rk) по 6 байт, в конце colLast
+			for i := 0; 4+i*6+6 <= len(rec.data)-2 && err == nil; i++ {
+				rk := binary.LittleEndian.Uint32(rec.data[4+i*6+2:])
+				err = set(row, col+i, formatXlsNumber(rkValue(rk)))
+			}
+		case xlsRecordBoolErr:
+			if len(rec.data) >= 8 && rec.data[7] == 0 {
+				err = set(row, col, strconv.FormatBool(rec.data[6] != 0))
+			}
+		case xlsRecordFormula:
+			if len(rec.data) < 14 {
+				return nil, errXlsTruncated
+			}
+			result := rec.data[6:14]
+			if result[6] == 0xFF && result[7] == 0xFF {
+				switch result[0] {
+				case 0: // строка, значение в следующей записи STRING
+					formulaRow, formulaCol = row, col
+				case 1:
+					err = set(row, col, strconv.FormatBool(result[2] != 0))
+				}
+			} else {
+				err = set(row, col, formatXlsNumber(math.Float64frombits(binary.LittleEndian.Uint64(result))))
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// xlsString читает строку XLUnicodeString (lenSize = 2) или ShortXLUnicodeString (lenSize = 1).
+// Возвращает строку и кол-во прочитанных байт.
+func xlsString(data []byte, lenSize int) (string, int, error) {
+	if len(data) < lenSize+1 {
+		return "", 0, errXlsTruncated
+	}
+
+	cch := int(data[0])
+	if lenSize == 2 {
+		cch = int(binary.LittleEndian.Uint16(data))
+	}
+	highByte := data[lenSize]&0x01 != 0
+	pos := lenSize + 1
+
+	charSize := 1
+	if highByte {
+		charSize = 2
+	}
+	if pos+cch*charSize > len(data) {
+		return "", 0, errXlsTruncated
+	}
+
+	return decodeXlsChars(data[pos:pos+cch*charSize], highByte), pos + cch*charSize, nil
+}
+
+// decodeXlsChars декодирует символы строки: UTF-16LE либо "сжатые" символы, у которых старший байт равен 0.
+func decodeXlsChars(data []byte, highByte bool) string {
+	if !highByte {
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+
+	u := make([]uint16, len(data)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// sstReader читает данные SST, разбитые на запись SST и следующие за ней CONTINUE. Если на границу записей
+// попадают символы строки, продолжение начинается с байта флагов, заново задающего размер символа.
+type sstReader struct {
+	segments [][]byte
+	seg      int
+	pos      int
+}
+
+func (r *sstReader) advance() bool {
+	for r.seg < len(r.segments) && r.pos >= len(r.segments[r.seg]) {
+		r.seg++
+		r.pos = 0
+	}
+	return r.seg < len(r.segments)
+}
+
+func (r *sstReader) bytes(n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		if !r.advance() {
+			return nil, errXlsTruncated
+		}
+		seg := r.segments[r.seg]
+		take := n - len(out)
+		if rest := len(seg) - r.pos; take > rest {
+			take = rest
+		}
+		out = append(out, seg[r.pos:r.pos+take]...)
+		r.pos += take
+	}
+	return out, nil
+}
+
+// skip пропускает n байт, не копируя их: n берётся из файла и может быть сколь угодно большим.
+func (r *sstReader) skip(n int) error {
+	for n > 0 {
+		if !r.advance() {
+			return errXlsTruncated
+		}
+		take := n
+		if rest := len(r.segments[r.seg]) - r.pos; take > rest {
+			take = rest
+		}
+		r.pos += take
+		n -= take
+	}
+	return nil
+}
+
+func (r *sstReader) chars(cch int, highByte bool) (string, error) {
+	var out []rune
+	for cch > 0 {
+		if r.pos >= len(r.segments[r.seg]) {
+			// Символы строки продолжаются в следующей записи CONTINUE
+			r.seg++
+			r.pos = 0
+			if r.seg >= len(r.segments) || len(r.segments[r.seg]) == 0 {
+				return "", errXlsTruncated
+			}
+			highByte = r.segments[r.seg][0]&0x01 != 0
+			r.pos = 1
+		}
+
+		charSize := 1
+		if highByte {
+			charSize = 2
+		}
+		available := (len(r.segments[r.seg]) - r.pos) / charSize
+		if available == 0 {
+			return "", errXlsTruncated
+		}
+		take := cch
+		if take > available {
+			take = available
+		}
+
+		out = append(out, []rune(decodeXlsChars(r.segments[r.seg][r.pos:r.pos+take*charSize], highByte))...)
+		r.pos += take * charSize
+		cch -= take
+	}
+	return string(out), nil
+}
+
+func readSST(segments [][]byte) ([]string, error) {
+	r := &sstReader{segments: segments}
+
+	head, err := r.bytes(8)
+	if err != nil {
+		return nil, err
+	}
+	unique := int(binary.LittleEndian.Uint32(head[4:]))
+
+	// Кол-во строк взято из файла, поэтому не доверяем ему при выделении памяти
+	sst := make([]string, 0, minInt(unique, 4096))
+	for i := 0; i < unique; i++ {
+		hdr, err := r.bytes(3)
+		if err != nil {
+			return nil, err
+		}
+		cch := int(binary.LittleEndian.Uint16(hdr))
+		flags := hdr[2]
+
+		runs, extSize := 0, 0
+		if flags&0x08 != 0 {
+			b, err := r.bytes(2)
+			if err != nil {
+				return nil, err
+			}
+			runs = int(binary.LittleEndian.Uint16(b))
+		}
+		if flags&0x04 != 0 {
+			b, err := r.bytes(4)
+			if err != nil {
+				return nil, err
+			}
+			extSize = int(binary.LittleEndian.Uint32(b))
+		}
+
+		s, err := r.chars(cch, flags&0x01 != 0)
+		if err != nil {
+			return nil, err
+		}
+		sst = append(sst, s)
+
+		// Форматирование (runs) и фонетические данные (ExtRst) нам не нужны
+		if err := r.skip(runs*4 + extSize); err != nil {
+			return nil, err
+		}
+	}
+
+	return sst, nil
+}
+
+// rkValue декодирует число в формате RK: либо 30-битное целое, либо старшие 30 бит double, возможно умноженные на 100.
+func rkValue(rk uint32) float64 {
+	var v float64
+	if rk&0x02 != 0 {
+		v = float64(int32(rk) >> 2)
+	} else {
+		v = math.Float64frombits(uint64(rk&0xFFFFFFFC) << 32)
+	}
+	if rk&0x01 != 0 {
+		v /= 100
+	}
+	return v
+}
+
+// formatXlsNumber форматирует число так же, как excelize отдаёт числа без формата: без экспоненты и лишних нулей.
+func formatXlsNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func xlsRecordBytes(typ uint16, data []byte) []byte {
+	rec := make([]byte, 4, 4+len(data))
+	binary.LittleEndian.PutUint16(rec, typ)
+	binary.LittleEndian.PutUint16(rec[2:], uint16(len(data)))
+	return append(rec, data...)
+}
+
+// xlsStream собирает поток Workbook, в котором sheets записей BOUNDSHEET указывают на один и тот же лист
+// с ячейкой A1 = 1.
+func xlsStream(sheets int) []byte {
+	bof := xlsRecordBytes(xlsRecordBOF, []byte{0x00, 0x06, 0x05, 0x00})
+
+	globals := append([]byte{}, bof...)
+	boundSheetSize := 4 + 8 + 1
+	sheetOffset := len(bof) + sheets*boundSheetSize + 4
+	for i := 0; i < sheets; i++ {
+		data := make([]byte, 8, 9)
+		binary.LittleEndian.PutUint32(data, uint32(sheetOffset))
+		data[6] = 1
+		globals = append(globals, xlsRecordBytes(xlsRecordBoundSheet, append(data, byte('a'+i%26)))...)
+	}
+	globals = append(globals, xlsRecordBytes(xlsRecordEOF, nil)...)
+
+	rk := make([]byte, 10)
+	binary.LittleEndian.PutUint32(rk[6:], 1<<2|0x02)
+	globals = append(globals, bof...)
+	globals = append(globals, xlsRecordBytes(xlsRecordRK, rk)...)
+	return append(globals, xlsRecordBytes(xlsRecordEOF, nil)...)
+}
+
+func TestXlsSheetsLimit(t *testing.T) {
+	l := DefaultLimits()
+	l.MaxSheets = 3
+
+	_, _, err := xlsGlobals(xlsStream(4), l.MaxSheets)
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || !errors.Is(err, ErrTooManySheets) {
+		t.Fatalf("err = %v, want ErrTooManySheets", err)
+	}
+
+	list, sst, err := xlsGlobals(xlsStream(3), l.MaxSheets)
+	if err != nil {
+		t.Fatalf("xlsGlobals failed: %v", err)
+	}
+	wb := &xlsWorkbook{stream: xlsStream(3), list: list, sst: sst, limits: l, decoded: make(map[int][][]string)}
+	for _, s := range wb.sheets() {
+		rows, err := wb.rows(s.name, 0)
+		if err != nil || len(rows) != 1 || rows[0][0] != "1" {
+			t.Fatalf("rows(%q) = %v, %v", s.name, rows, err)
+		}
+	}
+	// Все листы указывают на одно смещение, и лист читается один раз
+	if len(wb.decoded) != 1 {
+		t.Errorf("decoded %d sheets, want 1", len(wb.decoded))
+	}
+}
+
+// Размер пропускаемых данных строки SST берётся из файла, и огромное значение не должно приводить к выделению памяти.
+func TestReadSSTHugeExtSize(t *testing.T) {
+	data := make([]byte, 8, 16)
+	binary.LittleEndian.PutUint32(data[4:], 1)
+	data = append(data, 1, 0, 0x04, 0xFF, 0xFF, 0xFF, 0xFF, 'a')
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := readSST([][]byte{data}); !errors.Is(err, errXlsTruncated) {
+		t.Fatalf("err = %v, want errXlsTruncated", err)
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("readSST allocated %d bytes", allocated)
+	}
+}
@@ -2,17 +2,23 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/Geniuskaa/micro_registration/internal/config"
 	"github.com/Geniuskaa/micro_registration/internal/database"
 	"github.com/Geniuskaa/micro_registration/internal/mail"
+	"github.com/Geniuskaa/micro_registration/internal/parser"
 	"github.com/Geniuskaa/micro_registration/internal/sports/karate"
 	"github.com/fsnotify/fsnotify"
 	"github.com/go-chi/chi/v5"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
+	"mime"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type Server struct {
@@ -33,8 +39,8 @@ func (s *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 }
 
 func (s *Server) Init(atom zap.AtomicLevel, reg *prometheus.Registry) {
-	// Бросает панику, если возникла ошибка при инициализации
-	karateServ := karate.NewService(s.db, s.ctx)
+	karateServ := karate.NewService(s.db, s.ctx, s.logger)
+	go karateServ.WatchCategories(time.Minute * time.Duration(s.cfg.Karate.CategoryReloadMinutes))
 	mailServ := mail.NewService(s.cfg, s.logger, karateServ)
 
 	// Сейчас вызов функции происходит по запросу, в будущем она будет вызываться через сервис (горутина с каналом),
@@ -44,6 +50,10 @@ func (s *Server) Init(atom zap.AtomicLevel, reg *prometheus.Registry) {
 		mailServ.CheckMails()
 	})
 
+	s.mux.With(s.recoverer).Get("/api/v1/competitions/{uuid}/template", s.karateTemplate(karateServ))
+	s.mux.With(s.recoverer).Get("/api/v1/karate/categories/status", s.karateCategoryStatus(karateServ))
+	s.mux.With(s.recoverer).Post("/api/v1/competitions/{uuid}/categories/copy", s.karateCopyCategories(karateServ))
+
 	//serv := user.NewService(s.db, s.logger)
 	//
 	//s.mux.Mount("/internal", technic.NewHandler(s.ctx, s.logger, atom, reg).Routes())
@@ -69,6 +79,104 @@ func (s *Server) Start(addr string) error {
 	return s.serv.ListenAndServe()
 }
 
+// karateTemplate отдаёт xlsx-шаблон заявки на соревнование с uuid из пути запроса.
+func (s *Server) karateTemplate(karateServ *karate.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		c, err := karateServ.Competition(chi.URLParam(request, "uuid"))
+		if errors.Is(err, karate.ErrCompetitionNotFound) {
+			http.Error(writer, karate.ErrCompetitionNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.logger.Error("karateServ.Competition failed", zap.Error(err))
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := parser.KarateTemplate(c, karateServ.KumiteCategories(c.UUID))
+		if err != nil {
+			s.logger.Error("parser.KarateTemplate failed", zap.Error(err))
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", parser.XLSX_CONTENT_TYPE)
+		writer.Header().Set("Content-Disposition",
+			mime.FormatMediaType("attachment", map[string]string{"filename": parser.TemplateFilename(c)}))
+		writer.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if _, err := writer.Write(data); err != nil {
+			s.logger.Warn("template writing failed", zap.Error(err))
+		}
+	}
+}
+
+// karateCategoryStatus отдаёт версию и количество загруженных категорий карате. Пока категории не загружены,
+// отвечает 503: заявки в это время не принимаются.
+func (s *Server) karateCategoryStatus(karateServ *karate.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		status := karateServ.CategoryStatus()
+
+		data, err := json.Marshal(status)
+		if err != nil {
+			s.logger.Error("json.Marshal failed", zap.Error(err))
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		if !status.Loaded {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if _, err := writer.Write(data); err != nil {
+			s.logger.Warn("category status writing failed", zap.Error(err))
+		}
+	}
+}
+
+// karateCopyCategories копирует соревнованию из пути запроса набор категорий соревнования из параметра from.
+// С параметром clone=true соревнование получает собственные копии категорий.
+func (s *Server) karateCopyCategories(karateServ *karate.Service) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		clone := false
+		if v := request.URL.Query().Get("clone"); v != "" {
+			var err error
+			if clone, err = strconv.ParseBool(v); err != nil {
+				http.Error(writer, "Некорректное значение параметра clone", http.StatusBadRequest)
+				return
+			}
+		}
+
+		count, err := karateServ.CopyCategories(request.URL.Query().Get("from"), chi.URLParam(request, "uuid"), clone)
+		switch {
+		case errors.Is(err, karate.ErrCompetitionNotFound):
+			http.Error(writer, karate.ErrCompetitionNotFound.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, karate.ErrCategorySetSameCompetition):
+			http.Error(writer, karate.ErrCategorySetSameCompetition.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, karate.ErrCompetitionHasParticipants):
+			http.Error(writer, karate.ErrCompetitionHasParticipants.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			s.logger.Error("karateServ.CopyCategories failed", zap.Error(err))
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(map[string]int{"categories": count})
+		if err != nil {
+			s.logger.Error("json.Marshal failed", zap.Error(err))
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("Content-Type", "application/json")
+		if _, err := writer.Write(data); err != nil {
+			s.logger.Warn("copy categories response writing failed", zap.Error(err))
+		}
+	}
+}
+
 func (s *Server) recoverer(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 
@@ -76,7 +184,7 @@ func (s *Server) recoverer(handler http.Handler) http.Handler {
 			if err := recover(); err != nil {
 				writer.WriteHeader(http.StatusInternalServerError)
 				writer.Write([]byte("Something going wrong..."))
-				s.logger.Error("panic occurred:", zap.Error(fmt.Errorf("", err))) //Подумать как можно подписать получше
+				s.logger.Error("panic occurred:", zap.Error(fmt.Errorf("%v", err))) //Подумать как можно подписать получше
 			}
 		}()
 		handler.ServeHTTP(writer, request)
@@ -1,17 +1,366 @@
 package karate
 
-import "github.com/jackc/pgtype"
+import (
+	"errors"
+	"fmt"
+	"github.com/Geniuskaa/micro_registration/internal/names"
+	"github.com/jackc/pgtype"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Participant struct {
-	FullName   string           `json:"full_name"`
+	Row        int    `json:"row"`       // Номер строки участника в файле заявки (с 1)
+	FullName   string `json:"full_name"` // Нормализованное ФИО, см. names.Parse
+	Surname    string `json:"surname"`
+	FirstName  string `json:"first_name"`
+	Patronymic string `json:"patronymic"`
+
+	FullNameLatin string `json:"full_name_latin,omitempty"` // Заполняется, если включена транслитерация
+
 	Sex        string           `json:"sex"`
 	Age        uint8            `json:"age"`
+	BirthDate  time.Time        `json:"birth_date"` // Нулевая, если в заявке указан только возраст
 	Kyi        uint8            `json:"kyū"`
 	Dan        uint8            `json:"dan"`
 	City       string           `json:"city"`
 	KataKumite [2]bool          `json:"kata_kumite"`
 	KataGroup  bool             `json:"kata_group"`
+	KataTeam   string           `json:"kata_team"` // Команда группового ката, "" - если участник не в команде
 	Weight     float32          `json:"weight"`
 	Category   pgtype.Int4range `json:"category"` // Пока так, потом подумать как лучше
 	Coach      string           `json:"coach"`
 }
+
+// ParticipantKey - ключ, по которому участник считается одним и тем же человеком: ФИО без учёта регистра,
+// пробелов, ё/е и алфавита (см. names.FullName.Key) плюс возраст на день соревнований. Ключ строится по возрасту,
+// даже если указана дата рождения: тогда тот же участник, присланный сначала с возрастом, а потом с датой рождения,
+// найдётся по одному ключу. Одинаковые ФИО и возраст у разных людей встречаются, поэтому участников с одним ключом
+// дополнительно сверяют по дате рождения, см. SameBirthDate.
+func ParticipantKey(fullName string, age uint8) string {
+	return fmt.Sprintf("%s|%d", names.Parse(fullName).Key(), age)
+}
+
+// SameBirthDate сверяет даты рождения участников с одним ParticipantKey. Разными людьми они считаются, только
+// если даты рождения указаны у обоих и не совпадают.
+func SameBirthDate(a, b time.Time) bool {
+	return a.IsZero() || b.IsZero() || a.Equal(b)
+}
+
+// AgeOn возвращает кол-во полных лет на дату on. По правилам федерации возрастная категория определяется
+// возрастом на день соревнований.
+func AgeOn(birthDate, on time.Time) uint8 {
+	age := on.Year() - birthDate.Year()
+	if on.Month() < birthDate.Month() || (on.Month() == birthDate.Month() && on.Day() < birthDate.Day()) {
+		age--
+	}
+	if age < 0 {
+		return 0
+	}
+	if age > math.MaxUint8 {
+		return math.MaxUint8
+	}
+	return uint8(age)
+}
+
+// Club - команда, подавшая заявку, и контакты её представителя. Сохраняется вместе с каждым участником заявки.
+type Club struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Phone string `json:"phone"`
+	City  string `json:"city"`
+}
+
+// Значения пола, как в karate_category.sex. SEX_ANY - категория для мальчиков и девочек вместе.
+const (
+	SEX_MALE   = "м"
+	SEX_FEMALE = "ж"
+	SEX_ANY    = "о"
+)
+
+var (
+	ErrCategoryNotFound     = errors.New("Не найдена категория для участника")
+	ErrWeightOutOfCategory  = errors.New("Вес участника не подходит для его категории")
+	ErrCategoryNotSpecified = errors.New("Не указана весовая категория")
+	ErrCategoryAmbiguous    = errors.New("Участнику подходит несколько категорий соревнования")
+)
+
+// CategoryError - указанная в заявке весовая категория не найдена среди категорий кумите для возраста и пола
+// участника (Err = ErrCategoryNotFound), вес участника в неё не попадает (Err = ErrWeightOutOfCategory) или ей
+// соответствует несколько категорий соревнования (Err = ErrCategoryAmbiguous, Available - эти категории).
+type CategoryError struct {
+	Declared  pgtype.Int4range
+	Weight    float32
+	Available []string // Весовые категории, которые есть для возраста и пола участника
+	Err       error
+}
+
+func (e *CategoryError) Error() string {
+	weight := strconv.FormatFloat(float64(e.Weight), 'f', -1, 32)
+	if errors.Is(e.Err, ErrWeightOutOfCategory) {
+		return fmt.Sprintf("Вес %s кг не подходит для категории %s", weight, FormatWeightCategory(e.Declared))
+	}
+	if errors.Is(e.Err, ErrCategoryAmbiguous) {
+		return fmt.Sprintf("Категории %s соответствует несколько категорий соревнования: %s. Укажите категорию "+
+			"с обеими границами", FormatWeightCategory(e.Declared), strings.Join(e.Available, ", "))
+	}
+	if errors.Is(e.Err, ErrCategoryNotSpecified) {
+		return fmt.Sprintf("Не указана весовая категория, для возраста и пола участника есть: %s",
+			strings.Join(e.Available, ", "))
+	}
+	// Категория не указана, а подобрать её по весу не получилось
+	if e.Declared.Status != pgtype.Present {
+		return fmt.Sprintf("Для веса %s кг нет категории, для возраста и пола участника есть: %s",
+			weight, strings.Join(e.Available, ", "))
+	}
+	return fmt.Sprintf("Категории %s нет для возраста и пола участника, есть: %s",
+		FormatWeightCategory(e.Declared), strings.Join(e.Available, ", "))
+}
+
+// NoCategoryError - для участника нет категории дисциплины (Err = ErrCategoryNotFound) или подходит несколько
+// (Err = ErrCategoryAmbiguous). Хранит данные, по которым искали категорию, чтобы тренер видел, что исправить.
+type NoCategoryError struct {
+	Discipline string // DISCIPLINE_KATA или DISCIPLINE_KUMITE
+	GroupKata  bool
+	Age        uint8
+	Sex        string
+	Kyi        uint8
+	Weight     float32 // Вес указывается только для кумите
+	Err        error
+}
+
+func (e *NoCategoryError) Error() string {
+	discipline := "личного ката"
+	switch {
+	case e.Discipline == DISCIPLINE_KUMITE:
+		discipline = "кумите"
+	case e.GroupKata:
+		discipline = "группового ката"
+	}
+
+	sex := e.Sex
+	if sex == "" {
+		sex = "не указан"
+	}
+	details := fmt.Sprintf("возраст %d, пол %s", e.Age, sex)
+	if e.Kyi != 0 {
+		details += fmt.Sprintf(", кю %d", e.Kyi)
+	}
+	if e.Discipline == DISCIPLINE_KUMITE && e.Weight > 0 {
+		details += fmt.Sprintf(", вес %s кг", strconv.FormatFloat(float64(e.Weight), 'f', -1, 32))
+	}
+
+	if errors.Is(e.Err, ErrCategoryAmbiguous) {
+		return fmt.Sprintf("Участнику подходит несколько категорий %s (%s), обратитесь к организаторам соревнования",
+			discipline, details)
+	}
+	return fmt.Sprintf("Нет категории %s для участника: %s", discipline, details)
+}
+
+func (e *NoCategoryError) Unwrap() error {
+	return e.Err
+}
+
+func noCategoryError(p *Participant, discipline string, groupKata bool, err error) *NoCategoryError {
+	e := &NoCategoryError{Discipline: discipline, GroupKata: groupKata, Age: p.Age, Sex: p.Sex, Kyi: p.Kyi, Err: err}
+	if discipline == DISCIPLINE_KUMITE {
+		e.Weight = p.Weight
+	}
+	return e
+}
+
+// Competition - соревнование, на которое подаются заявки.
+type Competition struct {
+	UUID      string    `json:"uuid"`
+	Date      time.Time `json:"date"`
+	City      string    `json:"city"`
+	SportType string    `json:"sport_type"`
+	Status    string    `json:"status"`
+}
+
+var ErrCompetitionNotFound = errors.New("Соревнование не найдено")
+
+var (
+	ErrCategorySetSameCompetition = errors.New("Набор категорий копируется из другого соревнования")
+	ErrCompetitionHasParticipants = errors.New("На соревнование уже зарегистрированы участники, " +
+		"набор категорий изменить нельзя")
+)
+
+// KumiteCategories - весовые категории кумите для одного возраста и пола.
+type KumiteCategories struct {
+	Age     pgtype.Int4range
+	Sex     string
+	Weights []pgtype.Int4range // По возрастанию веса
+}
+
+// Кол-во участников в команде группового ката
+const KATA_TEAM_SIZE = 3
+
+var (
+	ErrTeamSize       = errors.New("В команде ката должно быть 3 участника")
+	ErrTeamAgeMix     = errors.New("Участники команды ката должны быть из одной возрастной категории")
+	ErrTeamIncomplete = errors.New("часть участников команды не удалось сохранить, команда не зарегистрирована, " +
+		"остальные участники зарегистрированы без группового ката")
+	ErrTeamNotSaved = errors.New("команду не удалось сохранить, участники зарегистрированы без группового ката")
+)
+
+// Team - команда группового ката. Участники команды выступают в одной категории группового ката.
+type Team struct {
+	Name       string        `json:"name"`
+	CategoryId int           `json:"category_id"`
+	Members    []Participant `json:"members"`
+}
+
+// TeamError - состав команды ката не подходит для регистрации (Err - ErrTeamSize или ErrTeamAgeMix) либо команду
+// не удалось записать (ErrTeamIncomplete, ErrTeamNotSaved).
+type TeamError struct {
+	Team string
+	Size int
+	Ages []uint8
+	Err  error
+}
+
+func (e *TeamError) Error() string {
+	if errors.Is(e.Err, ErrTeamSize) {
+		return fmt.Sprintf("Команда \"%s\": в команде ката должно быть %d участника, в заявке %d", e.Team,
+			KATA_TEAM_SIZE, e.Size)
+	}
+	if errors.Is(e.Err, ErrTeamAgeMix) {
+		ages := make([]string, 0, len(e.Ages))
+		for _, a := range e.Ages {
+			ages = append(ages, strconv.Itoa(int(a)))
+		}
+		return fmt.Sprintf("Команда \"%s\": участники должны быть из одной возрастной категории, их возраст: %s",
+			e.Team, strings.Join(ages, ", "))
+	}
+	return fmt.Sprintf("Команда \"%s\": %s", e.Team, e.Err.Error())
+}
+
+func (e *TeamError) Unwrap() error {
+	return e.Err
+}
+
+// TeamKey - ключ, по которому строки заявки относятся к одной команде: название без учёта регистра и пробелов.
+func TeamKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// CategoryAssignment - весовая категория кумите, подобранная участнику по весу. Declared - категория из заявки,
+// если она была указана.
+type CategoryAssignment struct {
+	Participant Participant
+	Declared    pgtype.Int4range
+	Assigned    pgtype.Int4range
+}
+
+// Conflict сообщает, что в заявке была указана другая категория.
+func (a CategoryAssignment) Conflict() bool {
+	return a.Declared.Status == pgtype.Present && !categoryMatches(a.Declared, a.Assigned)
+}
+
+func (e *CategoryError) Unwrap() error {
+	return e.Err
+}
+
+// FormatWeightCategory записывает весовую категорию так, как её пишут в заявках: "-45", "45-50", "70+".
+func FormatWeightCategory(r pgtype.Int4range) string {
+	return formatRange(r)
+}
+
+// FormatAgeCategory записывает возрастную категорию: "12-13", "18+".
+func FormatAgeCategory(r pgtype.Int4range) string {
+	return formatRange(r)
+}
+
+func formatRange(r pgtype.Int4range) string {
+	if r.Status != pgtype.Present {
+		return ""
+	}
+	lower, upper := r.LowerType != pgtype.Unbounded, r.UpperType != pgtype.Unbounded
+	switch {
+	case lower && upper:
+		return fmt.Sprintf("%d-%d", r.Lower.Int, inclusiveUpper(r))
+	case upper:
+		return fmt.Sprintf("-%d", inclusiveUpper(r))
+	case lower:
+		return fmt.Sprintf("%d+", r.Lower.Int)
+	}
+	return "без ограничения веса"
+}
+
+// inclusiveUpper возвращает верхнюю границу категории включительно. Postgres хранит int4range в виде [a,b).
+func inclusiveUpper(r pgtype.Int4range) int32 {
+	if r.UpperType == pgtype.Exclusive {
+		return r.Upper.Int - 1
+	}
+	return r.Upper.Int
+}
+
+// Допустимый процент строк с ошибками, если у соревнования не задан свой
+const DEFAULT_ERROR_THRESHOLD = 50
+
+// UploadPolicy - настройки соревнования, по которым решается, принимать ли заявку с ошибками в строках.
+type UploadPolicy struct {
+	ErrorThreshold float64 `json:"error_threshold"` // Допустимый процент строк с ошибками
+	Strict         bool    `json:"strict"`          // Любая строка с ошибкой отклоняет заявку целиком
+}
+
+// Rejects решает, отклонить ли заявку, в которой invalidRows строк с ошибками, что составляет percentErrs процентов.
+func (p UploadPolicy) Rejects(invalidRows int, percentErrs float64) bool {
+	if p.Strict {
+		return invalidRows > 0
+	}
+	return percentErrs > p.ErrorThreshold
+}
+
+// Mode - как записывать участников заявки. Строгое соревнование не принимает заявку частично.
+func (p UploadPolicy) Mode() UploadMode {
+	if p.Strict {
+		return UPLOAD_ATOMIC
+	}
+	return UPLOAD_PARTIAL
+}
+
+// UploadMode определяет, что делать, если часть участников заявки не удалось записать в БД.
+type UploadMode int
+
+const (
+	UPLOAD_PARTIAL UploadMode = iota // Записать всех, кого получилось, остальных вернуть в Response.FailedParticipants
+	UPLOAD_ATOMIC                    // Не записывать заявку вовсе, если не удалось записать хотя бы одного участника
+)
+
+// Итог обработки строки заявки, см. RowOutcome.
+const (
+	OUTCOME_ADDED       = "ADDED"
+	OUTCOME_UPDATED     = "UPDATED"
+	OUTCOME_DUPLICATE   = "DUPLICATE"
+	OUTCOME_FAILED      = "FAILED"
+	OUTCOME_ROLLED_BACK = "ROLLED_BACK" // Участник был бы записан, но заявка отменена целиком
+)
+
+// RowOutcome - что стало с участником из строки Row заявки.
+type RowOutcome struct {
+	Row      int
+	FullName string
+	Status   string
+	Err      error
+}
+
+var ErrUploadRolledBack = errors.New("Заявка не сохранена: соревнование принимает заявку только целиком, а часть " +
+	"участников записать не удалось")
+
+// WriteError - участника не удалось записать в БД. Err - ошибка БД, тренеру она не показывается.
+type WriteError struct {
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return "Не удалось сохранить участника. Попробуйте отправить заявку ещё раз, а если ошибка повторится - " +
+		"напишите в службу поддержки"
+}
+
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,216 @@
+package karate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"hash/fnv"
+	"time"
+)
+
+const (
+	// Канал, в который триггеры на karate_category и competition_category шлют уведомление об изменении
+	// (08_karate_category_notify.up.sql, 09_competition_category.up.sql)
+	CATEGORY_CHANNEL = "karate_category_changed"
+
+	DEFAULT_CATEGORY_RELOAD_INTERVAL = 5 * time.Minute
+	CATEGORY_RETRY_INTERVAL          = 10 * time.Second
+)
+
+var ErrCategoriesNotLoaded = errors.New("Категории соревнований ещё не загружены, повторите попытку позже")
+
+// CategoryStatus - состояние загруженных в память категорий. Version растёт при каждой замене категорий,
+// Checksum - контрольная сумма строк karate_category и competition_category, по ней видно, что разные экземпляры
+// сервиса загрузили одно и то же.
+type CategoryStatus struct {
+	Loaded    bool      `json:"loaded"`
+	Version   uint64    `json:"version"`
+	Checksum  string    `json:"checksum"`
+	LoadedAt  time.Time `json:"loaded_at"`  // Когда категории последний раз менялись
+	CheckedAt time.Time `json:"checked_at"` // Когда категории последний раз читались из БД
+	Total     int       `json:"total"`
+	Kata      int       `json:"kata"`
+	GroupKata int       `json:"group_kata"`
+	Kumite    int       `json:"kumite"`
+	// Кол-во соревнований со своим набором категорий, остальные используют общие категории
+	Competitions int    `json:"competitions"`
+	Listening    bool   `json:"listening"` // Подписан ли сервис на уведомления CATEGORY_CHANNEL
+	LastError    string `json:"last_error,omitempty"`
+}
+
+// CategoryStatus возвращает состояние загруженных категорий.
+func (s *Service) CategoryStatus() CategoryStatus {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	return s.status
+}
+
+// categories возвращает текущие категории соревнований. Индекс не меняется, а заменяется целиком, поэтому читать
+// его можно без блокировок.
+func (s *Service) categories() *categoryIndex {
+	if index, ok := s.index.Load().(*categoryIndex); ok {
+		return index
+	}
+	return newCategoryIndex(nil, nil)
+}
+
+// reloadCategories перечитывает karate_category и competition_category. Если загрузить категории не удалось,
+// остаются прежние.
+func (s *Service) reloadCategories() error {
+	categories, err := loadCategories(s.db.Pool, s.ctx)
+	var links []competitionLink
+	if err == nil {
+		links, err = loadCompetitionLinks(s.db.Pool, s.ctx)
+	}
+	if err != nil {
+		err = fmt.Errorf("reloadCategories failed: %w", err)
+	}
+	s.storeCategories(categories, links, err, time.Now())
+	return err
+}
+
+// storeCategories заменяет индекс категорий, если строки karate_category или competition_category изменились.
+func (s *Service) storeCategories(categories []Category, links []competitionLink, loadErr error, now time.Time) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+
+	s.status.CheckedAt = now
+	if loadErr != nil {
+		s.status.LastError = loadErr.Error()
+		return
+	}
+	s.status.LastError = ""
+
+	checksum := categoriesChecksum(categories, links)
+	if s.status.Loaded && checksum == s.status.Checksum {
+		return
+	}
+
+	index := newCategoryIndex(categories, links)
+	s.index.Store(index)
+
+	s.status.Loaded = true
+	s.status.Version++
+	s.status.Checksum = checksum
+	s.status.LoadedAt = now
+	s.status.Total = len(categories)
+	s.status.Competitions = len(index.byCompetition)
+	s.status.Kata, s.status.GroupKata, s.status.Kumite = 0, 0, 0
+	for _, c := range categories {
+		switch {
+		case c.KataKumite == DISCIPLINE_KUMITE:
+			s.status.Kumite++
+		case c.GroupKata:
+			s.status.GroupKata++
+		default:
+			s.status.Kata++
+		}
+	}
+}
+
+func (s *Service) setListening(listening bool) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	s.status.Listening = listening
+}
+
+// reloadDelay - через сколько перечитать категории: после ошибки загрузки повтор идёт чаще, чем по расписанию.
+func (s *Service) reloadDelay(interval time.Duration) time.Duration {
+	status := s.CategoryStatus()
+	if (!status.Loaded || status.LastError != "") && CATEGORY_RETRY_INTERVAL < interval {
+		return CATEGORY_RETRY_INTERVAL
+	}
+	return interval
+}
+
+// WatchCategories перечитывает karate_category раз в interval и по уведомлению от триггера на таблице, пока не
+// отменён контекст сервиса. Блокирует вызывающего, запускать в отдельной горутине.
+func (s *Service) WatchCategories(interval time.Duration) {
+	if interval <= 0 {
+		interval = DEFAULT_CATEGORY_RELOAD_INTERVAL
+	}
+
+	notify := make(chan struct{}, 1)
+	go s.listenCategories(notify)
+
+	timer := time.NewTimer(s.reloadDelay(interval))
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-timer.C:
+		case <-notify:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+
+		if err := s.reloadCategories(); err != nil {
+			s.logger.Error("s.reloadCategories failed", zap.Error(err))
+		}
+		timer.Reset(s.reloadDelay(interval))
+	}
+}
+
+// listenCategories держит подписку на CATEGORY_CHANNEL и переподключается, если соединение с БД потеряно.
+func (s *Service) listenCategories(notify chan<- struct{}) {
+	for {
+		err := s.listen(notify)
+		s.setListening(false)
+		if s.ctx.Err() != nil {
+			return
+		}
+		s.logger.Warn("category notifications are lost, reconnecting", zap.Error(err))
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(CATEGORY_RETRY_INTERVAL):
+		}
+	}
+}
+
+// listen подписывается на CATEGORY_CHANNEL на отдельном соединении: соединение с LISTEN нельзя возвращать в пул.
+func (s *Service) listen(notify chan<- struct{}) error {
+	pooled, err := s.db.Pool.Acquire(s.ctx)
+	if err != nil {
+		return fmt.Errorf("listen failed: %w", err)
+	}
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(s.ctx, "listen "+CATEGORY_CHANNEL); err != nil {
+		return fmt.Errorf("listen failed: %w", err)
+	}
+	s.setListening(true)
+
+	// Пока подписки не было, уведомления могли потеряться
+	signal(notify)
+	for {
+		if _, err := conn.WaitForNotification(s.ctx); err != nil {
+			return fmt.Errorf("listen failed: %w", err)
+		}
+		signal(notify)
+	}
+}
+
+// signal не блокирует: несколько уведомлений подряд достаточно обработать одной перезагрузкой.
+func signal(notify chan<- struct{}) {
+	select {
+	case notify <- struct{}{}:
+	default:
+	}
+}
+
+func categoriesChecksum(categories []Category, links []competitionLink) string {
+	h := fnv.New64a()
+	for _, c := range categories {
+		fmt.Fprintf(h, "%v\n", c)
+	}
+	for _, l := range links {
+		fmt.Fprintf(h, "%d:%d\n", l.CompetitionId, l.CategoryId)
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
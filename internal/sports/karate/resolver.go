@@ -0,0 +1,294 @@
+package karate
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"sort"
+	"strings"
+)
+
+// Дисциплины, как в karate_category.kata_or_kumite
+const (
+	DISCIPLINE_KATA   = "кат"
+	DISCIPLINE_KUMITE = "кум"
+)
+
+// Category - строка karate_category. Незаданный (NULL) диапазон кю или веса не ограничивает участников.
+type Category struct {
+	Id         int
+	KataKumite string
+	Sex        string
+	Age        pgtype.Int4range
+	Kyi        pgtype.Int4range
+	Weight     pgtype.Int4range
+	GroupKata  bool
+	// Соревнование, для которого категория скопирована, 0 - общая категория
+	OwnerCompetitionId int64
+}
+
+// CategoryResolver подбирает участнику категории только по строкам karate_category: возраст, кю и вес участника
+// должны попадать в диапазоны категории. Диапазоны сравниваются по границам, поэтому не важно, записаны они
+// в БД как [10,11] или как [10,12). Чтобы добавить возрастную группу, достаточно добавить категории в БД.
+type CategoryResolver struct {
+	categories []Category
+}
+
+func NewCategoryResolver(categories []Category) *CategoryResolver {
+	return &CategoryResolver{categories: categories}
+}
+
+// competitionLink - строка competition_category: категория, выбранная соревнованием.
+type competitionLink struct {
+	CompetitionId   int64
+	CompetitionUUID string
+	CategoryId      int
+}
+
+// categoryIndex - категории всех соревнований. Соревнование, для которого в competition_category нет ни одной
+// строки, использует все общие категории karate_category: так работали соревнования до появления наборов категорий.
+// Копии категорий, принадлежащие соревнованиям, в общие не входят, иначе каждая категория нашлась бы дважды.
+type categoryIndex struct {
+	all            *CategoryResolver
+	byCompetition  map[int64]*CategoryResolver
+	competitionIds map[string]int64 // uuid соревнования -> id
+}
+
+func newCategoryIndex(categories []Category, links []competitionLink) *categoryIndex {
+	byId := make(map[int]Category, len(categories))
+	global := make([]Category, 0, len(categories))
+	for _, c := range categories {
+		byId[c.Id] = c
+		if c.OwnerCompetitionId == 0 {
+			global = append(global, c)
+		}
+	}
+
+	sets := make(map[int64][]Category)
+	index := &categoryIndex{all: NewCategoryResolver(global), competitionIds: make(map[string]int64)}
+	for _, l := range links {
+		index.competitionIds[strings.ToLower(l.CompetitionUUID)] = l.CompetitionId
+		if c, ok := byId[l.CategoryId]; ok {
+			sets[l.CompetitionId] = append(sets[l.CompetitionId], c)
+		}
+	}
+
+	index.byCompetition = make(map[int64]*CategoryResolver, len(sets))
+	for id, set := range sets {
+		index.byCompetition[id] = NewCategoryResolver(set)
+	}
+	return index
+}
+
+// forCompetition возвращает категории соревнования с указанным id.
+func (i *categoryIndex) forCompetition(id int64) *CategoryResolver {
+	if r, ok := i.byCompetition[id]; ok {
+		return r
+	}
+	return i.all
+}
+
+// forUUID возвращает категории соревнования с указанным uuid.
+func (i *categoryIndex) forUUID(uuid string) *CategoryResolver {
+	if id, ok := i.competitionIds[strings.ToLower(strings.TrimSpace(uuid))]; ok {
+		return i.forCompetition(id)
+	}
+	return i.all
+}
+
+func loadCategories(pool *pgxpool.Pool, ctx context.Context) ([]Category, error) {
+	rows, err := pool.Query(ctx, `select id, kata_or_kumite, sex, age, kyi, weight, group_kata, owner_competition_id 
+					from karate_category order by id;`)
+	if err != nil {
+		return nil, fmt.Errorf("loadCategories failed: %w", err)
+	}
+	defer rows.Close()
+
+	categories := make([]Category, 0, 64)
+	for rows.Next() {
+		c := Category{}
+		var groupKata *bool
+		var owner *int64
+		err := rows.Scan(&c.Id, &c.KataKumite, &c.Sex, &c.Age, &c.Kyi, &c.Weight, &groupKata, &owner)
+		if err != nil {
+			return nil, fmt.Errorf("loadCategories failed: %w", err)
+		}
+		c.GroupKata = groupKata != nil && *groupKata
+		if owner != nil {
+			c.OwnerCompetitionId = *owner
+		}
+		categories = append(categories, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("loadCategories failed: %w", err)
+	}
+
+	return categories, nil
+}
+
+func loadCompetitionLinks(pool *pgxpool.Pool, ctx context.Context) ([]competitionLink, error) {
+	rows, err := pool.Query(ctx, `select cc.competition_id, c.uuid::text, cc.karate_category_id from competition_category cc 
+					join competition c on c.id = cc.competition_id order by cc.competition_id, cc.karate_category_id;`)
+	if err != nil {
+		return nil, fmt.Errorf("loadCompetitionLinks failed: %w", err)
+	}
+	defer rows.Close()
+
+	links := make([]competitionLink, 0, 64)
+	for rows.Next() {
+		l := competitionLink{}
+		if err := rows.Scan(&l.CompetitionId, &l.CompetitionUUID, &l.CategoryId); err != nil {
+			return nil, fmt.Errorf("loadCompetitionLinks failed: %w", err)
+		}
+		links = append(links, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("loadCompetitionLinks failed: %w", err)
+	}
+
+	return links, nil
+}
+
+// Kata возвращает id категории личного ката для участника. Если категории нет или подходит несколько,
+// вернёт *NoCategoryError.
+func (r *CategoryResolver) Kata(p *Participant) (int, error) {
+	id, err := single(r.match(p, DISCIPLINE_KATA, false))
+	if err != nil {
+		return 0, noCategoryError(p, DISCIPLINE_KATA, false, err)
+	}
+	return id, nil
+}
+
+// GroupKata возвращает id категории группового ката для участника. Если категории нет или подходит несколько,
+// вернёт *NoCategoryError.
+func (r *CategoryResolver) GroupKata(p *Participant) (int, error) {
+	id, err := single(r.match(p, DISCIPLINE_KATA, true))
+	if err != nil {
+		return 0, noCategoryError(p, DISCIPLINE_KATA, true, err)
+	}
+	return id, nil
+}
+
+// Kumite возвращает весовые категории кумите, подходящие участнику по возрасту, полу и кю, по возрастанию веса.
+// Вес участника не учитывается: по нему категорию выбирает вызывающий.
+func (r *CategoryResolver) Kumite(p *Participant) []Category {
+	list := r.match(p, DISCIPLINE_KUMITE, false)
+	sortByWeight(list)
+	return list
+}
+
+// KumiteCategories возвращает весовые категории кумите по возрастам и полу, упорядоченные по возрасту и полу.
+func (r *CategoryResolver) KumiteCategories() []KumiteCategories {
+	type group struct {
+		age pgtype.Int4range
+		sex string
+	}
+	byGroup := make(map[group][]Category)
+	for _, c := range r.categories {
+		if c.KataKumite != DISCIPLINE_KUMITE {
+			continue
+		}
+		g := group{age: c.Age, sex: c.Sex}
+		byGroup[g] = append(byGroup[g], c)
+	}
+
+	list := make([]KumiteCategories, 0, len(byGroup))
+	for g, categories := range byGroup {
+		sortByWeight(categories)
+		weights := make([]pgtype.Int4range, 0, len(categories))
+		for _, c := range categories {
+			weights = append(weights, c.Weight)
+		}
+		list = append(list, KumiteCategories{Age: g.age, Sex: g.sex, Weights: weights})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Age.Lower.Int != list[j].Age.Lower.Int {
+			return list[i].Age.Lower.Int < list[j].Age.Lower.Int
+		}
+		return list[i].Sex < list[j].Sex
+	})
+	return list
+}
+
+// match отбирает категории дисциплины, в которые участник попадает по возрасту, полу и кю. Категория SEX_ANY
+// подходит участнику любого пола. Кю сверяется, только если он указан: у обладателей дана кю обычно не пишут.
+// Пол сравнивается без учёта регистра: "М" в заявке - это тоже SEX_MALE.
+func (r *CategoryResolver) match(p *Participant, kataKumite string, groupKata bool) []Category {
+	sex := strings.ToLower(strings.TrimSpace(p.Sex))
+	list := make([]Category, 0, 8)
+	for _, c := range r.categories {
+		if c.KataKumite != kataKumite || c.GroupKata != groupKata {
+			continue
+		}
+		if c.Sex != SEX_ANY && c.Sex != sex {
+			continue
+		}
+		if !rangeContains(c.Age, int32(p.Age)) {
+			continue
+		}
+		if p.Kyi != 0 && c.Kyi.Status == pgtype.Present && !rangeContains(c.Kyi, int32(p.Kyi)) {
+			continue
+		}
+		list = append(list, c)
+	}
+	return list
+}
+
+// single возвращает id единственной подходящей категории. Если подходит несколько, в karate_category пересекаются
+// диапазоны, и выбирать между ними наугад нельзя.
+func single(list []Category) (int, error) {
+	switch len(list) {
+	case 0:
+		return 0, ErrCategoryNotFound
+	case 1:
+		return list[0].Id, nil
+	}
+
+	ids := make([]int, 0, len(list))
+	for _, c := range list {
+		ids = append(ids, c.Id)
+	}
+	return 0, fmt.Errorf("categories %v: %w", ids, ErrCategoryAmbiguous)
+}
+
+// rangeContains проверяет, что v попадает в диапазон. Незаданный (NULL) или пустой диапазон не содержит ничего.
+func rangeContains(r pgtype.Int4range, v int32) bool {
+	if r.Status != pgtype.Present || r.LowerType == pgtype.Empty {
+		return false
+	}
+
+	switch r.LowerType {
+	case pgtype.Inclusive:
+		if v < r.Lower.Int {
+			return false
+		}
+	case pgtype.Exclusive:
+		if v <= r.Lower.Int {
+			return false
+		}
+	}
+	switch r.UpperType {
+	case pgtype.Inclusive:
+		if v > r.Upper.Int {
+			return false
+		}
+	case pgtype.Exclusive:
+		if v >= r.Upper.Int {
+			return false
+		}
+	}
+	return true
+}
+
+// sortByWeight упорядочивает категории по возрастанию веса: первой идёт категория без нижней границы.
+func sortByWeight(list []Category) {
+	sort.SliceStable(list, func(i, j int) bool {
+		a, b := list[i].Weight, list[j].Weight
+		if a.LowerType == pgtype.Unbounded || b.LowerType == pgtype.Unbounded {
+			return a.LowerType == pgtype.Unbounded && b.LowerType != pgtype.Unbounded
+		}
+		return a.Lower.Int < b.Lower.Int
+	})
+}
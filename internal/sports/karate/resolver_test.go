@@ -0,0 +1,262 @@
+package karate
+
+import (
+	"errors"
+	"github.com/jackc/pgtype"
+	"testing"
+	"time"
+)
+
+func int4range(t *testing.T, s string) pgtype.Int4range {
+	t.Helper()
+	r := pgtype.Int4range{}
+	if s == "" {
+		return r
+	}
+	if err := r.DecodeText(nil, []byte(s)); err != nil {
+		t.Fatalf("r.DecodeText(%q) failed: %v", s, err)
+	}
+	return r
+}
+
+// Категории записаны и так, как в 01_init.up.sql ([10,11]), и так, как их возвращает Postgres ([12,14)).
+func testResolver(t *testing.T) *CategoryResolver {
+	t.Helper()
+	category := func(id int, kataKumite, sex, age, kyi, weight string, group bool) Category {
+		return Category{Id: id, KataKumite: kataKumite, Sex: sex, Age: int4range(t, age), Kyi: int4range(t, kyi),
+			Weight: int4range(t, weight), GroupKata: group}
+	}
+	return NewCategoryResolver([]Category{
+		category(1, DISCIPLINE_KATA, SEX_ANY, "[10,11]", "[1,10]", "", false),
+		category(2, DISCIPLINE_KATA, SEX_MALE, "[12,14)", "[1,10]", "", false),
+		category(3, DISCIPLINE_KATA, SEX_FEMALE, "[12,14)", "[1,10]", "", false),
+		category(4, DISCIPLINE_KATA, SEX_MALE, "[18,)", "", "", false),
+		category(5, DISCIPLINE_KATA, SEX_ANY, "[12,13]", "", "", true),
+		category(6, DISCIPLINE_KUMITE, SEX_MALE, "[12,13]", "", "[40,45]", false),
+		category(7, DISCIPLINE_KUMITE, SEX_MALE, "[12,13]", "", "[,35]", false),
+		category(8, DISCIPLINE_KUMITE, SEX_MALE, "[12,14)", "", "[35,41)", false),
+		category(9, DISCIPLINE_KUMITE, SEX_MALE, "[12,13]", "", "[60,)", false),
+		// Пересекается с категорией 4 для 18-летних
+		category(10, DISCIPLINE_KATA, SEX_MALE, "[16,18]", "", "", false),
+	})
+}
+
+func TestCategoryResolverKata(t *testing.T) {
+	r := testResolver(t)
+
+	tests := []struct {
+		name    string
+		p       Participant
+		group   bool
+		want    int
+		wantErr error
+	}{
+		{name: "inclusive_upper", p: Participant{Age: 11, Sex: SEX_FEMALE, Kyi: 9}, want: 1},
+		{name: "canonical_upper", p: Participant{Age: 13, Sex: SEX_MALE, Kyi: 8}, want: 2},
+		{name: "by_sex", p: Participant{Age: 12, Sex: SEX_FEMALE, Kyi: 8}, want: 3},
+		{name: "upper_case_sex", p: Participant{Age: 12, Sex: "М", Kyi: 8}, want: 2},
+		{name: "unbounded_age", p: Participant{Age: 40, Sex: SEX_MALE, Dan: 3}, want: 4},
+		{name: "dan_without_kyi", p: Participant{Age: 13, Sex: SEX_MALE, Dan: 1}, want: 2},
+		{name: "group", p: Participant{Age: 13, Sex: SEX_FEMALE, Kyi: 8}, group: true, want: 5},
+		{name: "too_young", p: Participant{Age: 8, Sex: SEX_MALE, Kyi: 10}, wantErr: ErrCategoryNotFound},
+		{name: "no_group_for_age", p: Participant{Age: 11, Sex: SEX_MALE, Kyi: 10}, group: true,
+			wantErr: ErrCategoryNotFound},
+		{name: "overlapping_ages", p: Participant{Age: 18, Sex: SEX_MALE, Kyi: 1}, wantErr: ErrCategoryAmbiguous},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var got int
+			var err error
+			if tt.group {
+				got, err = r.GroupKata(&tt.p)
+			} else {
+				got, err = r.Kata(&tt.p)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("got %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// Ошибка подбора категории хранит данные участника, по ним тренер поймёт, что исправить в заявке.
+func TestNoCategoryError(t *testing.T) {
+	r := testResolver(t)
+
+	_, err := r.GroupKata(&Participant{Age: 10, Sex: SEX_MALE, Kyi: 10})
+	var noCat *NoCategoryError
+	if !errors.As(err, &noCat) {
+		t.Fatalf("err = %v, want *NoCategoryError", err)
+	}
+	if noCat.Age != 10 || noCat.Sex != SEX_MALE || noCat.Kyi != 10 || !noCat.GroupKata {
+		t.Errorf("error = %+v", noCat)
+	}
+	if want := "Нет категории группового ката для участника: возраст 10, пол м, кю 10"; err.Error() != want {
+		t.Errorf("message = %q, want %q", err.Error(), want)
+	}
+
+	_, err = (&Service{}).idFinderKumite(r, &Participant{Age: 30, Sex: SEX_MALE, Weight: 80.5})
+	if !errors.As(err, &noCat) || noCat.Discipline != DISCIPLINE_KUMITE || noCat.Weight != 80.5 {
+		t.Fatalf("err = %v, want *NoCategoryError for kumite", err)
+	}
+}
+
+func TestCategoryResolverKumite(t *testing.T) {
+	r := testResolver(t)
+
+	got := r.Kumite(&Participant{Age: 13, Sex: SEX_MALE, Kyi: 8})
+	want := []int{7, 8, 6, 9}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want ids %v", got, want)
+	}
+	for i := range want {
+		if got[i].Id != want[i] {
+			t.Fatalf("got %+v, want ids %v", got, want)
+		}
+	}
+
+	if got := r.Kumite(&Participant{Age: 13, Sex: SEX_FEMALE}); len(got) != 0 {
+		t.Errorf("got %+v for a girl, want none", got)
+	}
+
+	s := &Service{}
+	p := Participant{Age: 13, Sex: SEX_MALE, Weight: 38, Category: int4range(t, "[35,40]")}
+	if id, err := s.idFinderKumite(r, &p); err != nil || id != 8 {
+		t.Errorf("idFinderKumite = %d, %v, want 8", id, err)
+	}
+}
+
+func TestIdFinderKumiteAmbiguous(t *testing.T) {
+	r := NewCategoryResolver([]Category{
+		{Id: 1, KataKumite: DISCIPLINE_KUMITE, Sex: SEX_MALE, Age: int4range(t, "[12,13]"), Weight: int4range(t, "[40,45]")},
+		{Id: 2, KataKumite: DISCIPLINE_KUMITE, Sex: SEX_MALE, Age: int4range(t, "[12,13]"), Weight: int4range(t, "[,45]")},
+	})
+
+	p := Participant{Age: 12, Sex: SEX_MALE, Weight: 42, Category: int4range(t, "(,46)")}
+	_, err := (&Service{}).idFinderKumite(r, &p)
+	var catErr *CategoryError
+	if !errors.As(err, &catErr) || !errors.Is(err, ErrCategoryAmbiguous) || len(catErr.Available) != 2 {
+		t.Fatalf("err = %v, want ambiguous category error", err)
+	}
+}
+
+func TestStoreCategories(t *testing.T) {
+	s := &Service{}
+	now := time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+	p := Participant{Age: 12, Sex: SEX_MALE, Kyi: 8}
+
+	if _, err := s.categories().forCompetition(1).Kata(&p); !errors.Is(err, ErrCategoryNotFound) {
+		t.Fatalf("err = %v before loading, want ErrCategoryNotFound", err)
+	}
+
+	categories := testResolver(t).categories
+	s.storeCategories(categories, nil, nil, now)
+	status := s.CategoryStatus()
+	if !status.Loaded || status.Version != 1 || status.Total != len(categories) || status.Kata != 5 ||
+		status.GroupKata != 1 || status.Kumite != 4 {
+		t.Fatalf("status = %+v", status)
+	}
+	if id, err := s.categories().forCompetition(1).Kata(&p); err != nil || id != 2 {
+		t.Fatalf("Kata = %d, %v, want 2", id, err)
+	}
+
+	// Те же строки не заменяют набор категорий
+	s.storeCategories(categories, nil, nil, now.Add(time.Minute))
+	if got := s.CategoryStatus(); got.Version != 1 || !got.LoadedAt.Equal(now) || !got.CheckedAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("status after unchanged reload = %+v", got)
+	}
+
+	// При ошибке загрузки остаются прежние категории
+	s.storeCategories(nil, nil, errors.New("connection refused"), now.Add(2*time.Minute))
+	if got := s.CategoryStatus(); got.Version != 1 || got.LastError == "" || got.Total != len(categories) {
+		t.Errorf("status after failed reload = %+v", got)
+	}
+	if id, err := s.categories().forCompetition(1).Kata(&p); err != nil || id != 2 {
+		t.Errorf("Kata after failed reload = %d, %v, want 2", id, err)
+	}
+
+	s.storeCategories(categories[:3], nil, nil, now.Add(3*time.Minute))
+	if got := s.CategoryStatus(); got.Version != 2 || got.Total != 3 || got.LastError != "" {
+		t.Errorf("status after change = %+v", got)
+	}
+	if got := s.categories().forCompetition(1).Kumite(&p); len(got) != 0 {
+		t.Errorf("kumite categories after change = %+v, want none", got)
+	}
+}
+
+func TestCategoryIndex(t *testing.T) {
+	categories := testResolver(t).categories
+	const uuid = "5A0C7D2E-8F39-4E5B-9C61-3D2B7A4E1F08"
+	index := newCategoryIndex(categories, []competitionLink{
+		{CompetitionId: 7, CompetitionUUID: "5a0c7d2e-8f39-4e5b-9c61-3d2b7a4e1f08", CategoryId: 1},
+		{CompetitionId: 7, CompetitionUUID: "5a0c7d2e-8f39-4e5b-9c61-3d2b7a4e1f08", CategoryId: 8},
+		// Ссылка на удалённую категорию не ломает набор
+		{CompetitionId: 7, CompetitionUUID: "5a0c7d2e-8f39-4e5b-9c61-3d2b7a4e1f08", CategoryId: 100},
+	})
+
+	p := Participant{Age: 12, Sex: SEX_MALE, Kyi: 8}
+	if _, err := index.forCompetition(7).Kata(&p); !errors.Is(err, ErrCategoryNotFound) {
+		t.Errorf("err = %v, want ErrCategoryNotFound: competition set has no kata for 12 years", err)
+	}
+	if got := index.forUUID(uuid).Kumite(&p); len(got) != 1 || got[0].Id != 8 {
+		t.Errorf("kumite by uuid = %+v, want category 8", got)
+	}
+	if id, err := index.forCompetition(8).Kata(&p); err != nil || id != 2 {
+		t.Errorf("Kata for competition without set = %d, %v, want 2", id, err)
+	}
+	if got := index.forUUID("unknown").Kumite(&p); len(got) != 4 {
+		t.Errorf("kumite for unknown uuid = %+v, want all categories", got)
+	}
+
+	s := &Service{}
+	s.storeCategories(categories, []competitionLink{{CompetitionId: 7, CategoryId: 1}}, nil, time.Now())
+	before := s.CategoryStatus()
+	s.storeCategories(categories, []competitionLink{{CompetitionId: 7, CategoryId: 2}}, nil, time.Now())
+	if after := s.CategoryStatus(); after.Version != before.Version+1 || after.Competitions != 1 {
+		t.Errorf("status after set change = %+v, before %+v", after, before)
+	}
+}
+
+// Копии категорий, сделанные CopyCategories для одного соревнования, не попадают к соревнованиям без своего набора:
+// иначе каждая категория находилась бы дважды.
+func TestCategoryIndexClonedSet(t *testing.T) {
+	global := testResolver(t).categories
+	categories := append([]Category(nil), global...)
+	links := make([]competitionLink, 0, len(global))
+	for _, c := range global {
+		clone := c
+		clone.Id += 100
+		clone.OwnerCompetitionId = 7
+		categories = append(categories, clone)
+		links = append(links, competitionLink{CompetitionId: 7, CategoryId: clone.Id})
+	}
+	index := newCategoryIndex(categories, links)
+
+	p := Participant{Age: 12, Sex: SEX_MALE, Kyi: 8, Weight: 38, Category: int4range(t, "[35,40]")}
+	tests := []struct {
+		competition int64
+		offset      int
+	}{
+		{competition: 7, offset: 100},
+		{competition: 8},
+	}
+	for _, tt := range tests {
+		r := index.forCompetition(tt.competition)
+		if id, err := r.Kata(&p); err != nil || id != 2+tt.offset {
+			t.Errorf("competition %d: Kata = %d, %v, want %d", tt.competition, id, err, 2+tt.offset)
+		}
+		if id, err := r.GroupKata(&p); err != nil || id != 5+tt.offset {
+			t.Errorf("competition %d: GroupKata = %d, %v, want %d", tt.competition, id, err, 5+tt.offset)
+		}
+		if id, err := (&Service{}).idFinderKumite(r, &p); err != nil || id != 8+tt.offset {
+			t.Errorf("competition %d: idFinderKumite = %d, %v, want %d", tt.competition, id, err, 8+tt.offset)
+		}
+	}
+}
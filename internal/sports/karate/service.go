@@ -5,286 +5,975 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Geniuskaa/micro_registration/internal/database"
+	"github.com/Geniuskaa/micro_registration/internal/names"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Service struct {
-	db         *database.Postgres
-	ctx        context.Context
-	categories map[string]map[pgtype.Int4range]map[string]*catIdLeaf
+	db     *database.Postgres
+	ctx    context.Context
+	logger *zap.Logger
+
+	index    atomic.Value // *categoryIndex, заменяется целиком при перезагрузке категорий, см. WatchCategories
+	statusMu sync.Mutex
+	status   CategoryStatus
 }
 
 //func (s *Service) SportName() string  {
 //	return "karate"
 //}
 
-type competitionCategory struct {
-	id         int
-	kataKumite string
-	sex        string
-	age        pgtype.Int4range
-	kyi        pgtype.Int4range
-	weight     pgtype.Int4range
-	groupKata  bool
+type Response struct {
+	CountOfFailedRows   int
+	ErrsOfFailedRows    []error
+	AddedParticipants   []string
+	CountOfAddedParts   int
+	UpdatedParticipants []string // Уже зарегистрированные участники, данные которых обновлены
+	CountOfUpdatedParts int
+	Duplicates          []Participant // Уже зарегистрированные участники, которые не были обновлены
+	FailedParticipants  []FailedParticipant
+	CategoryAssignments []CategoryAssignment // Категории кумите, подобранные по весу
+	Teams               []Team               // Зарегистрированные команды группового ката
+	TeamlessMembers     []FailedParticipant  // Записаны без группового ката: их команда не зарегистрирована
+	RolledBack          bool                 // Заявка не сохранена целиком, см. UPLOAD_ATOMIC
+	Outcomes            []RowOutcome         // Итог по каждой строке заявки, по возрастанию номера строки
 }
 
-type catIdLeaf struct {
-	categoryId int
-	weightMap  map[pgtype.Int4range]int
+// FailedParticipant - участник, для которого не удалось подобрать категорию или которого не удалось записать в БД.
+type FailedParticipant struct {
+	Participant Participant
+	Err         error
 }
 
-type Response struct {
-	CountOfFailedRows int
-	ErrsOfFailedRows  []error
-	AddedParticipants []string
-	CountOfAddedParts int
+// NewService загружает категории karate_category. Если БД недоступна, сервис всё равно создаётся: категории
+// загрузит WatchCategories, а до тех пор заявки не принимаются (ErrCategoriesNotLoaded).
+func NewService(db *database.Postgres, ctx context.Context, logger *zap.Logger) *Service {
+	s := &Service{db: db, ctx: ctx, logger: logger}
+	if err := s.reloadCategories(); err != nil {
+		logger.Error("s.reloadCategories failed", zap.Error(err))
+	}
+	return s
 }
 
-func NewService(db *database.Postgres, ctx context.Context) *Service {
+// Competition возвращает соревнование с указанным uuid. Если uuid некорректный или соревнования нет,
+// вернёт ErrCompetitionNotFound.
+func (s *Service) Competition(uuid string) (Competition, error) {
+	id := pgtype.UUID{}
+	if err := id.Set(uuid); err != nil {
+		return Competition{}, fmt.Errorf("Competition failed: %w", ErrCompetitionNotFound)
+	}
 
-	m, err := categoryMapInitializer(db.Pool, ctx)
+	row := s.db.Pool.QueryRow(s.ctx, `SELECT uuid::text, comp_date, city, sport_type, status from competition 
+					where uuid = $1`, id)
+	c, err := scanCompetition(row)
 	if err != nil {
-		panic(err)
+		return Competition{}, fmt.Errorf("Competition failed: %w", err)
 	}
 
-	return &Service{db: db, ctx: ctx, categories: m}
+	return c, nil
 }
 
-func categoryMapInitializer(pool *pgxpool.Pool, ctx context.Context) (map[string]map[pgtype.Int4range]map[string]*catIdLeaf, error) {
-	rows, err := pool.Query(ctx, `select id, kata_or_kumite, sex, age, kyi, weight, group_kata from karate_category;`)
+// CompetitionByDate ищет активное соревнование по дате. Если на дату приходится не одно соревнование,
+// вернёт ErrCompetitionNotFound: угадывать, о каком из них речь, нельзя.
+func (s *Service) CompetitionByDate(date time.Time) (Competition, error) {
+	rows, err := s.db.Pool.Query(s.ctx, `SELECT uuid::text, comp_date, city, sport_type, status from competition 
+					where comp_date = $1 and status = 'ACTIVE' limit 2`, date)
 	if err != nil {
-		return nil, fmt.Errorf("categoryMapInitializer failed: %w", err)
+		return Competition{}, fmt.Errorf("CompetitionByDate failed: %w", err)
 	}
-
 	defer rows.Close()
 
-	m := make(map[string]map[pgtype.Int4range]map[string]*catIdLeaf, 2)
-	m["кат"] = make(map[pgtype.Int4range]map[string]*catIdLeaf, 6)
-	m["кум"] = make(map[pgtype.Int4range]map[string]*catIdLeaf, 6)
-
+	found := make([]Competition, 0, 2)
 	for rows.Next() {
-		compCat := &competitionCategory{}
-
-		err := rows.Scan(&compCat.id, &compCat.kataKumite, &compCat.sex, &compCat.age, &compCat.kyi, &compCat.weight, &compCat.groupKata)
+		c, err := scanCompetition(rows)
 		if err != nil {
-			return nil, fmt.Errorf("categoryMapInitializer failed: %w", err)
+			return Competition{}, fmt.Errorf("CompetitionByDate failed: %w", err)
 		}
+		found = append(found, c)
+	}
+	if err = rows.Err(); err != nil {
+		return Competition{}, fmt.Errorf("CompetitionByDate failed: %w", err)
+	}
 
-		switch compCat.kataKumite {
-		case "кат":
-			v, ok := m["кат"][compCat.age]
-			if ok == false {
-				m["кат"][compCat.age] = make(map[string]*catIdLeaf, 3)
-				m["кат"][compCat.age][compCat.sex] = &catIdLeaf{categoryId: compCat.id}
-			} else {
-				v[compCat.sex] = &catIdLeaf{categoryId: compCat.id}
-			}
-		case "кум":
-			v, ok := m["кум"][compCat.age]
-			if ok == false {
-				m["кум"][compCat.age] = make(map[string]*catIdLeaf, 2)
-
-				_, exists := m["кум"][compCat.age][compCat.sex]
-				if exists == false {
-					m["кум"][compCat.age][compCat.sex] = &catIdLeaf{weightMap: make(map[pgtype.Int4range]int, 6)}
-					m["кум"][compCat.age][compCat.sex].weightMap[compCat.weight] = compCat.id
-				}
-				// Скорее всего это уже не нужно, но если что пусть первые 3 продакшен версии будет тут
-				//leave.weightMap[compCat.weight] = compCat.id
-
-			} else {
-				leave, exists := v[compCat.sex]
-				if exists == false {
-					v[compCat.sex] = &catIdLeaf{weightMap: make(map[pgtype.Int4range]int, 6)}
-					v[compCat.sex].weightMap[compCat.weight] = compCat.id
-				} else {
-					leave.weightMap[compCat.weight] = compCat.id
-				}
-			}
-		}
+	if len(found) != 1 {
+		return Competition{}, fmt.Errorf("CompetitionByDate failed: %w", ErrCompetitionNotFound)
+	}
+	return found[0], nil
+}
+
+// CopyCategories заменяет набор категорий соревнования toUUID набором соревнования fromUUID и возвращает кол-во
+// категорий в новом наборе. Если clone = true, соревнование получает копии строк karate_category, которые потом
+// можно менять, не затрагивая другие соревнования (копии принадлежат toUUID и не входят в общие категории),
+// иначе - те же категории. Набор нельзя менять, если на соревнование
+// уже зарегистрированы участники (ErrCompetitionHasParticipants).
+func (s *Service) CopyCategories(fromUUID, toUUID string, clone bool) (int, error) {
+	tx, err := s.db.Pool.Begin(s.ctx)
+	if err != nil {
+		return 0, fmt.Errorf("CopyCategories failed: %w", err)
+	}
+	defer tx.Rollback(s.ctx)
+
+	fromId, err := s.competitionId(tx, fromUUID)
+	if err != nil {
+		return 0, fmt.Errorf("CopyCategories failed: %w", err)
+	}
+	toId, err := s.competitionId(tx, toUUID)
+	if err != nil {
+		return 0, fmt.Errorf("CopyCategories failed: %w", err)
+	}
+	if fromId == toId {
+		return 0, fmt.Errorf("CopyCategories failed: %w", ErrCategorySetSameCompetition)
+	}
+
+	var registered bool
+	err = tx.QueryRow(s.ctx, `select exists(select 1 from karate_participant where competition_id = $1)`,
+		toId).Scan(&registered)
+	if err != nil {
+		return 0, fmt.Errorf("CopyCategories failed: %w", err)
+	}
+	if registered {
+		return 0, fmt.Errorf("CopyCategories failed: %w", ErrCompetitionHasParticipants)
+	}
+
+	// Соревнование без своего набора использует все общие категории, их и копируем. Копии категорий других
+	// соревнований в общие не входят
+	var ids []int32
+	err = tx.QueryRow(s.ctx, `select coalesce(
+			(select array_agg(karate_category_id order by karate_category_id) from competition_category 
+				where competition_id = $1),
+			(select array_agg(id order by id) from karate_category where owner_competition_id is null),
+			'{}')`, fromId).Scan(&ids)
+	if err != nil {
+		return 0, fmt.Errorf("CopyCategories failed: %w", err)
+	}
+
+	if _, err = tx.Exec(s.ctx, `delete from competition_category where competition_id = $1`, toId); err != nil {
+		return 0, fmt.Errorf("CopyCategories failed: %w", err)
+	}
+
+	query := `insert into competition_category (competition_id, karate_category_id) select $1, unnest($2::int[])`
+	if clone {
+		query = `with cloned as (
+				insert into karate_category (kata_or_kumite, sex, age, kyi, weight, group_kata, owner_competition_id)
+				select kata_or_kumite, sex, age, kyi, weight, group_kata, $1 from karate_category 
+				where id = any($2::int[]) order by id
+				returning id)
+			insert into competition_category (competition_id, karate_category_id) select $1, id from cloned`
+	}
+	tag, err := tx.Exec(s.ctx, query, toId, ids)
+	if err != nil {
+		return 0, fmt.Errorf("CopyCategories failed: %w", err)
+	}
+
+	if err = tx.Commit(s.ctx); err != nil {
+		return 0, fmt.Errorf("CopyCategories failed: %w", err)
+	}
+
+	// Триггер тоже пришлёт уведомление, но заявку на соревнование могут прислать раньше, чем оно дойдёт
+	if err := s.reloadCategories(); err != nil {
+		s.logger.Error("s.reloadCategories failed", zap.Error(err))
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+// competitionId возвращает id соревнования с указанным uuid. Если uuid некорректный или соревнования нет,
+// вернёт ErrCompetitionNotFound.
+func (s *Service) competitionId(tx pgx.Tx, uuid string) (int64, error) {
+	uid := pgtype.UUID{}
+	if err := uid.Set(uuid); err != nil {
+		return 0, ErrCompetitionNotFound
+	}
+
+	var id int64
+	err := tx.QueryRow(s.ctx, `select id from competition where uuid = $1`, uid).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrCompetitionNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("competitionId failed: %w", err)
+	}
+	return id, nil
+}
+
+func scanCompetition(row pgx.Row) (Competition, error) {
+	c := Competition{}
+	err := row.Scan(&c.UUID, &c.Date, &c.City, &c.SportType, &c.Status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c, ErrCompetitionNotFound
+	}
+	return c, err
+}
+
+// KumiteCategories возвращает весовые категории кумите соревнования с указанным uuid по возрастам и полу,
+// упорядоченные по возрасту и полу.
+func (s *Service) KumiteCategories(uuid string) []KumiteCategories {
+	return s.categories().forUUID(uuid).KumiteCategories()
+}
+
+// UploadPolicy возвращает настройки приёма заявок соревнования с указанным uuid. Если соревнования нет,
+// вернёт ErrCompetitionNotFound.
+func (s *Service) UploadPolicy(uuid string) (UploadPolicy, error) {
+	policy := UploadPolicy{ErrorThreshold: DEFAULT_ERROR_THRESHOLD}
 
+	row := s.db.Pool.QueryRow(s.ctx, `SELECT error_threshold, strict_upload from competition where uuid = $1`, uuid)
+	err := row.Scan(&policy.ErrorThreshold, &policy.Strict)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return policy, fmt.Errorf("UploadPolicy failed: %w", ErrCompetitionNotFound)
+	}
+	if err != nil {
+		return policy, fmt.Errorf("UploadPolicy failed: %w", err)
 	}
 
-	return m, nil
+	return policy, nil
 }
 
-func (s *Service) UploadParticipants(m map[string]interface{}, uuid string) (*Response, error) {
+// UploadParticipants добавляет участников в соревнование. Участник, который уже зарегистрирован на соревнование
+// (см. ParticipantKey), обновляется, если updateExisting = true, иначе попадает в Response.Duplicates.
+// Участники и команды записываются в одной транзакции пакетами запросов. Если кого-то записать не удалось - нет
+// категории, участник уже зарегистрирован, неверный состав команды или ошибка БД, - в режиме UPLOAD_ATOMIC заявка
+// не сохраняется вовсе (Response.RolledBack), а в UPLOAD_PARTIAL сохраняются все остальные.
+// Ошибка возвращается, только если не удалось работать с БД вообще или категории ещё не загружены.
+func (s *Service) UploadParticipants(m map[string]interface{}, uuid string, club Club, updateExisting bool, mode UploadMode) (*Response, error) {
+
+	// Без категорий все участники получили бы ошибку подбора категории, хотя заявка может быть верной
+	if !s.CategoryStatus().Loaded {
+		return nil, fmt.Errorf("UploadParticipants failed: %w", ErrCategoriesNotLoaded)
+	}
+
+	tx, err := s.db.Pool.Begin(s.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("UploadParticipants failed: %w", err)
+	}
+	defer tx.Rollback(s.ctx)
 
-	temp := s.db.Pool.QueryRow(s.ctx, `SELECT id from competition where uuid = $1`, uuid)
+	temp := tx.QueryRow(s.ctx, `SELECT id, comp_date, auto_kumite_category from competition where uuid = $1`, uuid)
 	var competId int64
-	err := temp.Scan(&competId)
+	var compDate time.Time
+	var autoCategory bool
+	err = temp.Scan(&competId, &compDate, &autoCategory)
 	if err != nil {
 		return nil, fmt.Errorf("Competition id cast to int64 failed: %w", err)
 	}
 
+	// Категории берутся один раз: если во время разбора заявки они перезагрузятся, заявка разберётся по прежним
+	resolver := s.categories().forCompetition(competId)
+
+	registered, err := s.registeredParticipants(tx, competId)
+	if err != nil {
+		return nil, fmt.Errorf("UploadParticipants failed: %w", err)
+	}
+
 	resp := Response{CountOfFailedRows: 0, ErrsOfFailedRows: make([]error, 0, len(m)), AddedParticipants: make([]string, 0, len(m)), CountOfAddedParts: 0}
-	rows := make([]pgx.Row, 0, len(m))
 
-	for _, v := range m {
-		p := v.(Participant)
+	parts := make([]Participant, 0, len(m))
+	for key, v := range m {
+		p, ok := v.(Participant)
+		if !ok {
+			return nil, fmt.Errorf("UploadParticipants failed: unexpected %T for %q", v, key)
+		}
+
+		// Парсер считает возраст по дате рождения на день разбора заявки, категория же определяется
+		// возрастом на день соревнований
+		if !p.BirthDate.IsZero() {
+			p.Age = AgeOn(p.BirthDate, compDate)
+		}
+		parts = append(parts, p)
+	}
+	// Запросы в пакете идут в порядке строк заявки, так проще разбираться с ошибками записи
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].Row < parts[j].Row
+	})
+
+	teams, teamErrs := s.kataTeams(resolver, parts)
+
+	writes := s.prepareWrites(&resp, resolver, parts, teamErrs, registered, club, autoCategory, updateExisting)
+
+	// В UPLOAD_ATOMIC заявка, в которой кого-то нельзя записать, не сохраняется, и писать её незачем
+	var saved []int64
+	writeErrs := make(map[int]error)
+	rolledBack := mode.rollsBack(&resp, writeErrs)
+	if !rolledBack {
+		saved, writeErrs, err = s.writeParticipants(tx, competId, club, writes, mode)
+		if err != nil {
+			return nil, fmt.Errorf("UploadParticipants failed: %w", err)
+		}
+		rolledBack = mode.rollsBack(&resp, writeErrs)
+	}
+
+	// id и данные записанных участников команд по TeamKey
+	members := make(map[string][]int64, len(teams))
+	written := make(map[string][]Participant, len(teams))
+	for i, w := range writes {
+		if err, ok := writeErrs[i]; ok {
+			resp.ErrsOfFailedRows = append(resp.ErrsOfFailedRows, err)
+			resp.FailedParticipants = append(resp.FailedParticipants, FailedParticipant{Participant: w.p, Err: err})
+			continue
+		}
+		if team := w.p.KataTeam; team != "" && !rolledBack {
+			members[TeamKey(team)] = append(members[TeamKey(team)], saved[i])
+			written[TeamKey(team)] = append(written[TeamKey(team)], w.p)
+		}
+	}
+
+	for key, team := range teams {
+		if rolledBack {
+			break
+		}
+
+		// Если кого-то из команды не удалось записать, команда остаётся неполной и не регистрируется
+		var teamErr error
+		if len(members[key]) != len(team.Members) {
+			teamErr = &TeamError{Team: team.Name, Size: len(members[key]), Err: ErrTeamIncomplete}
+		} else if err := s.saveTeam(tx, competId, club, team, members[key]); err != nil {
+			resp.ErrsOfFailedRows = append(resp.ErrsOfFailedRows, err)
+			if mode == UPLOAD_ATOMIC {
+				for _, p := range team.Members {
+					resp.FailedParticipants = append(resp.FailedParticipants,
+						FailedParticipant{Participant: p, Err: &WriteError{Err: err}})
+				}
+				rolledBack = true
+				continue
+			}
+			teamErr = &TeamError{Team: team.Name, Size: len(team.Members), Err: ErrTeamNotSaved}
+		}
+		if teamErr == nil {
+			resp.Teams = append(resp.Teams, *team)
+			continue
+		}
+
+		// Без команды участник не может выступать в групповом ката, а остальные его категории остаются
+		if err := s.dropGroupKata(tx, team, members[key]); err != nil {
+			return nil, fmt.Errorf("UploadParticipants failed: %w", err)
+		}
+		for _, p := range written[key] {
+			resp.ErrsOfFailedRows = append(resp.ErrsOfFailedRows, teamErr)
+			resp.TeamlessMembers = append(resp.TeamlessMembers, FailedParticipant{Participant: p, Err: teamErr})
+		}
+	}
+
+	if rolledBack {
+		// Транзакция откатывается отложенным tx.Rollback
+		resp.RolledBack = true
+		resp.Teams = nil
+		resp.TeamlessMembers = nil
+		resp.CountOfFailedRows = len(m)
+		resp.Outcomes = outcomesOf(&resp, writes, writeErrs)
+		return &resp, nil
+	}
+
+	if err = tx.Commit(s.ctx); err != nil {
+		return nil, fmt.Errorf("UploadParticipants failed: %w", err)
+	}
+
+	for i, w := range writes {
+		if _, ok := writeErrs[i]; ok {
+			continue
+		}
+		if w.assignment != nil {
+			resp.CategoryAssignments = append(resp.CategoryAssignments, *w.assignment)
+		}
+		if w.id != 0 {
+			resp.UpdatedParticipants = append(resp.UpdatedParticipants, w.p.FullName)
+		} else {
+			resp.AddedParticipants = append(resp.AddedParticipants, w.p.FullName)
+		}
+	}
+
+	sort.Slice(resp.Teams, func(i, j int) bool {
+		return resp.Teams[i].Members[0].Row < resp.Teams[j].Members[0].Row
+	})
+
+	resp.CountOfAddedParts = len(resp.AddedParticipants)
+	resp.CountOfUpdatedParts = len(resp.UpdatedParticipants)
+
+	if resp.CountOfFailedRows < (len(m) - resp.CountOfAddedParts - resp.CountOfUpdatedParts) {
+		resp.CountOfFailedRows = len(m) - resp.CountOfAddedParts - resp.CountOfUpdatedParts
+	}
+	resp.Outcomes = outcomesOf(&resp, writes, writeErrs)
+
+	return &resp, nil
+}
+
+// prepareWrites подбирает участникам заявки категории и отбирает тех, кого можно записать. Остальные попадают
+// в resp: участники с ошибками - в FailedParticipants, уже зарегистрированные - в Duplicates.
+func (s *Service) prepareWrites(resp *Response, resolver *CategoryResolver, parts []Participant,
+	teamErrs map[string]error, registered registeredParticipants, club Club, autoCategory, updateExisting bool) []participantWrite {
+
+	writes := make([]participantWrite, 0, len(parts))
+	for _, p := range parts {
+		// Команда с неверным составом не регистрируется, а без неё участник не может выступать в групповом ката
+		if err, ok := teamErrs[TeamKey(p.KataTeam)]; ok && p.KataTeam != "" {
+			resp.ErrsOfFailedRows = append(resp.ErrsOfFailedRows, err)
+			resp.FailedParticipants = append(resp.FailedParticipants, FailedParticipant{Participant: p, Err: err})
+			resp.CountOfFailedRows++
+			continue
+		}
 
 		ids := make([]int, 0, 3)
 
+		// Если соревнование подбирает категорию по весу, указанная в заявке категория только сверяется с подобранной
+		var assignment *CategoryAssignment
+		if autoCategory && p.KataKumite[1] {
+			a, err := s.assignKumiteCategory(resolver, &p)
+			if err != nil {
+				resp.ErrsOfFailedRows = append(resp.ErrsOfFailedRows, err)
+				resp.FailedParticipants = append(resp.FailedParticipants, FailedParticipant{Participant: p, Err: err})
+				resp.CountOfFailedRows++
+				continue
+			}
+			assignment = &a
+		}
+
 		// Всё, что тут происходит - не логгируется, в конце мы лишь запишем имена тех, кого успешно добавили и напишем
 		// сколько человек было с ошибкой.
 		if p.KataKumite[0] {
 			if p.KataKumite[1] && p.KataGroup { // ката + ката группа + кумите
 				// categories[] = {kata: true/false, kataGroup: true/false, kumite: true/false}
-				err = s.getCategoryIds(&ids, &p, [3]bool{true, true, true})
+				err := s.getCategoryIds(resolver, &ids, &p, [3]bool{true, true, true})
 				if err != nil {
 					resp.ErrsOfFailedRows = append(resp.ErrsOfFailedRows, err)
+					resp.FailedParticipants = append(resp.FailedParticipants, FailedParticipant{Participant: p, Err: err})
 					resp.CountOfFailedRows++
 					continue
 				}
 
 			} else if p.KataGroup { // ката + ката группа
 				// categories[] = {kata: true/false, kataGroup: true/false, kumite: true/false}
-				err = s.getCategoryIds(&ids, &p, [3]bool{true, true, false})
+				err := s.getCategoryIds(resolver, &ids, &p, [3]bool{true, true, false})
 				if err != nil {
 					resp.ErrsOfFailedRows = append(resp.ErrsOfFailedRows, err)
+					resp.FailedParticipants = append(resp.FailedParticipants, FailedParticipant{Participant: p, Err: err})
 					resp.CountOfFailedRows++
 					continue
 				}
 			} else if p.KataKumite[1] { // ката + кумите
 				// categories[] = {kata: true/false, kataGroup: true/false, kumite: true/false}
-				err = s.getCategoryIds(&ids, &p, [3]bool{true, false, true})
+				err := s.getCategoryIds(resolver, &ids, &p, [3]bool{true, false, true})
 				if err != nil {
 					resp.ErrsOfFailedRows = append(resp.ErrsOfFailedRows, err)
+					resp.FailedParticipants = append(resp.FailedParticipants, FailedParticipant{Participant: p, Err: err})
 					resp.CountOfFailedRows++
 					continue
 				}
 			} else { // только ката
 				// categories[] = {kata: true/false, kataGroup: true/false, kumite: true/false}
-				err = s.getCategoryIds(&ids, &p, [3]bool{true, false, false})
+				err := s.getCategoryIds(resolver, &ids, &p, [3]bool{true, false, false})
 				if err != nil {
 					resp.ErrsOfFailedRows = append(resp.ErrsOfFailedRows, err)
+					resp.FailedParticipants = append(resp.FailedParticipants, FailedParticipant{Participant: p, Err: err})
 					resp.CountOfFailedRows++
 					continue
 				}
 			}
 		} else { //только кумите
 			// categories[] = {kata: true/false, kataGroup: true/false, kumite: true/false}
-			err = s.getCategoryIds(&ids, &p, [3]bool{false, false, true})
+			err := s.getCategoryIds(resolver, &ids, &p, [3]bool{false, false, true})
 			if err != nil {
 				resp.ErrsOfFailedRows = append(resp.ErrsOfFailedRows, err)
+				resp.FailedParticipants = append(resp.FailedParticipants, FailedParticipant{Participant: p, Err: err})
 				resp.CountOfFailedRows++
 				continue
 			}
 		}
 
-		row := s.db.Pool.QueryRow(s.ctx, `insert into karate_participant (fullname, age, weight, kyi, dan, city, coach_fullname, 
-					competition_id, karate_category_ids) values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning karate_participant.fullname;`,
-			p.FullName, p.Age, p.Weight, p.Kyi, p.Dan, p.City, p.Coach, competId, pq.Array(ids))
-		rows = append(rows, row)
+		// Если город участника не указан, считаем что он из города клуба
+		if p.City == "" {
+			p.City = club.City
+		}
+
+		w := participantWrite{p: p, categoryIds: ids, assignment: assignment}
+		if id, ok := registered.find(p); ok {
+			if !updateExisting {
+				resp.Duplicates = append(resp.Duplicates, p)
+				resp.CountOfFailedRows++
+				continue
+			}
+			w.id = id
+		}
+		writes = append(writes, w)
 	}
 
-	for _, row := range rows {
-		var uploadedPart string
+	return writes
+}
+
+// rollsBack решает, отменить ли заявку целиком. В UPLOAD_ATOMIC заявка сохраняется, только если записать можно всех:
+// ни у кого нет ошибок, нет уже зарегистрированных участников, которых нельзя обновить, и запись в БД прошла.
+func (m UploadMode) rollsBack(resp *Response, writeErrs map[int]error) bool {
+	return m == UPLOAD_ATOMIC && (len(resp.ErrsOfFailedRows) != 0 || len(resp.Duplicates) != 0 || len(writeErrs) != 0)
+}
+
+// participantWrite - участник, прошедший проверки и готовый к записи. id - id уже зарегистрированного участника,
+// которого нужно обновить, 0 - новый участник.
+type participantWrite struct {
+	p           Participant
+	id          int64
+	categoryIds []int
+	assignment  *CategoryAssignment
+}
+
+// writeParticipants записывает участников пакетами запросов в транзакции tx. Возвращает id записанных участников
+// по индексам writes и ошибки записи по индексам тех, кого записать не удалось.
+//
+// После ошибки в пакете Postgres не выполняет следующие запросы, а транзакция остаётся прерванной. Поэтому пакет
+// отправляется внутри точки сохранения. В UPLOAD_ATOMIC первая же ошибка записи означает отказ от всей заявки.
+// В UPLOAD_PARTIAL после первой ошибки остальные участники записываются по одному, каждый в своей точке сохранения:
+// повтор пакета после каждой ошибки стоил бы квадратичного числа запросов на заявке со множеством ошибок.
+func (s *Service) writeParticipants(tx pgx.Tx, competId int64, club Club, writes []participantWrite,
+	mode UploadMode) (saved []int64, writeErrs map[int]error, err error) {
+
+	saved = make([]int64, len(writes))
+	writeErrs = make(map[int]error)
+	if len(writes) == 0 {
+		return saved, writeErrs, nil
+	}
 
-		err := row.Scan(&uploadedPart)
+	all := make([]int, len(writes))
+	for i := range all {
+		all[i] = i
+	}
+
+	failed, err := s.writeBatch(tx, competId, club, writes, all, saved)
+	if err != nil {
+		return nil, nil, err
+	}
+	if failed == nil {
+		return saved, writeErrs, nil
+	}
+	writeErrs[failed.idx] = &WriteError{Err: failed.err}
+	if mode == UPLOAD_ATOMIC {
+		return saved, writeErrs, nil
+	}
+
+	for _, i := range all {
+		if i == failed.idx {
+			continue
+		}
+		failed, err := s.writeBatch(tx, competId, club, writes, []int{i}, saved)
 		if err != nil {
-			resp.ErrsOfFailedRows = append(resp.ErrsOfFailedRows, err)
+			return nil, nil, err
+		}
+		if failed != nil {
+			writeErrs[i] = &WriteError{Err: failed.err}
+		}
+	}
+
+	return saved, writeErrs, nil
+}
+
+// writeBatch отправляет записи writes с индексами pending одним пакетом в точке сохранения и заполняет saved по
+// индексам writes. Если Postgres вернул ошибку на одной из записей, точка сохранения откатывается, а запись
+// возвращается в failed с индексом в writes.
+func (s *Service) writeBatch(tx pgx.Tx, competId int64, club Club, writes []participantWrite, pending []int,
+	saved []int64) (*failedWrite, error) {
+
+	sp, err := tx.Begin(s.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("writeBatch failed: %w", err)
+	}
+
+	ids, failed, err := s.sendWrites(sp, competId, club, writes, pending)
+	if err != nil {
+		sp.Rollback(s.ctx)
+		return nil, err
+	}
+	if failed != nil {
+		if err := sp.Rollback(s.ctx); err != nil {
+			return nil, fmt.Errorf("writeBatch failed: %w", err)
+		}
+		return &failedWrite{idx: pending[failed.idx], err: failed.err}, nil
+	}
+
+	if err := sp.Commit(s.ctx); err != nil {
+		return nil, fmt.Errorf("writeBatch failed: %w", err)
+	}
+	for j, i := range pending {
+		saved[i] = ids[j]
+	}
+	return nil, nil
+}
+
+// failedWrite - запрос пакета, на котором Postgres вернул ошибку. idx - индекс в пакете.
+type failedWrite struct {
+	idx int
+	err error
+}
+
+// sendWrites отправляет одним пакетом записи writes с индексами pending и возвращает id участников в порядке
+// pending. Ошибка Postgres на одной из записей возвращается в failed, err - только ошибка соединения.
+func (s *Service) sendWrites(tx pgx.Tx, competId int64, club Club, writes []participantWrite,
+	pending []int) (ids []int64, failed *failedWrite, err error) {
+
+	b := &pgx.Batch{}
+	for _, i := range pending {
+		queueWrite(b, competId, club, writes[i])
+	}
+
+	br := tx.SendBatch(s.ctx, b)
+	defer br.Close()
+
+	ids = make([]int64, len(pending))
+	for j := range pending {
+		var fullName string
+		err := br.QueryRow().Scan(&ids[j], &fullName)
+		if err == nil {
 			continue
 		}
 
-		resp.AddedParticipants = append(resp.AddedParticipants, uploadedPart)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			return nil, &failedWrite{idx: j, err: err}, nil
+		}
+		return nil, nil, fmt.Errorf("sendWrites failed: %w", err)
 	}
 
-	resp.CountOfAddedParts = len(resp.AddedParticipants)
+	return ids, nil, nil
+}
 
-	if resp.CountOfFailedRows < (len(m) - resp.CountOfAddedParts) {
-		resp.CountOfFailedRows = len(m) - resp.CountOfAddedParts
+// queueWrite добавляет в пакет запрос на добавление или обновление участника.
+func queueWrite(b *pgx.Batch, competId int64, club Club, w participantWrite) {
+	p := w.p
+	if w.id != 0 {
+		b.Queue(`update karate_participant set fullname = $1, age = $2, weight = $3, kyi = $4, 
+					dan = $5, city = $6, coach_fullname = $7, karate_category_ids = $8, club = $9, contact_email = $10, 
+					contact_phone = $11, birth_date = $12, surname = $13, first_name = $14, patronymic = $15, 
+					fullname_latin = $16, fullname_key = $17 where id = $18 returning karate_participant.id, karate_participant.fullname;`,
+			p.FullName, p.Age, p.Weight, p.Kyi, p.Dan, p.City, p.Coach, pq.Array(w.categoryIds),
+			nullIfEmpty(club.Name), nullIfEmpty(club.Email), nullIfEmpty(club.Phone), nullIfZero(p.BirthDate),
+			p.Surname, p.FirstName, nullIfEmpty(p.Patronymic), nullIfEmpty(p.FullNameLatin),
+			names.Parse(p.FullName).Key(), w.id)
+		return
 	}
 
-	return &resp, nil
+	b.Queue(`insert into karate_participant (fullname, age, weight, kyi, dan, city, coach_fullname, 
+					competition_id, karate_category_ids, club, contact_email, contact_phone, birth_date, surname, first_name, 
+					patronymic, fullname_latin, fullname_key) 
+					values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18) 
+					returning karate_participant.id, karate_participant.fullname;`,
+		p.FullName, p.Age, p.Weight, p.Kyi, p.Dan, p.City, p.Coach, competId, pq.Array(w.categoryIds),
+		nullIfEmpty(club.Name), nullIfEmpty(club.Email), nullIfEmpty(club.Phone), nullIfZero(p.BirthDate),
+		p.Surname, p.FirstName, nullIfEmpty(p.Patronymic), nullIfEmpty(p.FullNameLatin), names.Parse(p.FullName).Key())
 }
 
-func (s *Service) idFinderKata(p *Participant) (int, error) {
-	ageRange := pgtype.Int4range{}
-	var err error
-	switch p.Age {
-	case 10, 11:
-		err = ageRange.Set("[10,12)")
-	case 12, 13:
-		err = ageRange.Set("[12,14)")
-	case 14, 15:
-		err = ageRange.Set("[14,16)")
-	case 16, 17:
-		err = ageRange.Set("[16,18)")
-	default:
-		err = ageRange.Set("[18,)")
+// outcomesOf собирает итог по каждой строке заявки, отсортированный по номеру строки.
+func outcomesOf(resp *Response, writes []participantWrite, writeErrs map[int]error) []RowOutcome {
+	outcomes := make([]RowOutcome, 0, len(writes)+len(resp.FailedParticipants)+len(resp.Duplicates))
+
+	failedRows := make(map[int]bool, len(resp.FailedParticipants))
+	for _, f := range resp.FailedParticipants {
+		failedRows[f.Participant.Row] = true
+		outcomes = append(outcomes, RowOutcome{Row: f.Participant.Row, FullName: f.Participant.FullName,
+			Status: OUTCOME_FAILED, Err: f.Err})
+	}
+	for _, p := range resp.Duplicates {
+		outcomes = append(outcomes, RowOutcome{Row: p.Row, FullName: p.FullName, Status: OUTCOME_DUPLICATE})
+	}
+	for i, w := range writes {
+		if _, ok := writeErrs[i]; ok || failedRows[w.p.Row] {
+			continue
+		}
+
+		status := OUTCOME_ADDED
+		switch {
+		case resp.RolledBack:
+			status = OUTCOME_ROLLED_BACK
+		case w.id != 0:
+			status = OUTCOME_UPDATED
+		}
+		outcomes = append(outcomes, RowOutcome{Row: w.p.Row, FullName: w.p.FullName, Status: status})
+	}
+
+	sort.Slice(outcomes, func(i, j int) bool {
+		return outcomes[i].Row < outcomes[j].Row
+	})
+	return outcomes
+}
+
+// kataTeams собирает команды группового ката из участников заявки и проверяет их состав: KATA_TEAM_SIZE участников
+// одной категории группового ката. Команды с неверным составом возвращаются в teamErrs, ключ - TeamKey.
+func (s *Service) kataTeams(resolver *CategoryResolver, parts []Participant) (teams map[string]*Team, teamErrs map[string]error) {
+	teams = make(map[string]*Team)
+	teamErrs = make(map[string]error)
 
+	for _, p := range parts {
+		if p.KataTeam == "" {
+			continue
+		}
+		key := TeamKey(p.KataTeam)
+		if _, ok := teams[key]; !ok {
+			teams[key] = &Team{Name: p.KataTeam}
+		}
+		teams[key].Members = append(teams[key].Members, p)
 	}
+
+	for key, team := range teams {
+		sort.Slice(team.Members, func(i, j int) bool {
+			return team.Members[i].Row < team.Members[j].Row
+		})
+
+		if len(team.Members) != KATA_TEAM_SIZE {
+			teamErrs[key] = &TeamError{Team: team.Name, Size: len(team.Members), Err: ErrTeamSize}
+			delete(teams, key)
+			continue
+		}
+
+		ages := make([]uint8, 0, len(team.Members))
+		for i := range team.Members {
+			ages = append(ages, team.Members[i].Age)
+
+			id, err := s.idFinderGroupKata(resolver, &team.Members[i])
+			if err != nil {
+				teamErrs[key] = &TeamError{Team: team.Name, Size: len(team.Members), Err: err}
+				break
+			}
+			if i > 0 && id != team.CategoryId {
+				teamErrs[key] = &TeamError{Team: team.Name, Size: len(team.Members), Ages: ages, Err: ErrTeamAgeMix}
+			}
+			team.CategoryId = id
+		}
+		if _, ok := teamErrs[key]; ok {
+			delete(teams, key)
+		}
+	}
+
+	return teams, teamErrs
+}
+
+// saveTeam записывает команду и её состав в транзакции tx. При повторной заявке команда клуба с тем же названием
+// перезаписывается, а участники уходят из команд, в которых были раньше. Команда пишется в точке сохранения, чтобы
+// ошибка записи одной команды не прерывала всю транзакцию.
+func (s *Service) saveTeam(tx pgx.Tx, competId int64, club Club, team *Team, memberIds []int64) error {
+	sp, err := tx.Begin(s.ctx)
 	if err != nil {
-		return 0, fmt.Errorf("idFinderKata failed: %w", err)
+		return fmt.Errorf("saveTeam failed: %w", err)
 	}
+	defer sp.Rollback(s.ctx)
 
-	if p.Age == 10 || p.Age == 11 {
-		id := s.categories["кат"][ageRange]["о"].categoryId
-		return id, nil
+	var teamId int64
+	err = sp.QueryRow(s.ctx, `insert into karate_team (competition_id, karate_category_id, name, club) 
+					values ($1, $2, $3, $4) 
+					on conflict (competition_id, club, name) do update set karate_category_id = excluded.karate_category_id 
+					returning id;`, competId, team.CategoryId, team.Name, club.Name).Scan(&teamId)
+	if err != nil {
+		return fmt.Errorf("saveTeam failed: %w", err)
 	}
 
-	id := s.categories["кат"][ageRange][p.Sex].categoryId
-	return id, nil
+	_, err = sp.Exec(s.ctx, `delete from karate_team_member where team_id = $1 or participant_id = any($2);`,
+		teamId, pq.Array(memberIds))
+	if err != nil {
+		return fmt.Errorf("saveTeam failed: %w", err)
+	}
+
+	_, err = sp.Exec(s.ctx, `insert into karate_team_member (team_id, participant_id) select $1, unnest($2::bigint[]);`,
+		teamId, pq.Array(memberIds))
+	if err != nil {
+		return fmt.Errorf("saveTeam failed: %w", err)
+	}
+
+	if err = sp.Commit(s.ctx); err != nil {
+		return fmt.Errorf("saveTeam failed: %w", err)
+	}
+	return nil
 }
 
-func (s *Service) idFinderGroupKata(p *Participant) (int, error) {
-	ageRange := pgtype.Int4range{}
-	var err error
-	switch p.Age {
-	case 12, 13:
-		err = ageRange.Set("[12,14)")
-	case 14, 15:
-		err = ageRange.Set("[14,16)")
-	case 16, 17:
-		err = ageRange.Set("[16,18)")
-	default:
-		err = ageRange.Set("[18,)")
+// dropGroupKata убирает категорию группового ката команды у уже записанных участников memberIds, если команда
+// не зарегистрирована.
+func (s *Service) dropGroupKata(tx pgx.Tx, team *Team, memberIds []int64) error {
+	if len(memberIds) == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(s.ctx, `update karate_participant set karate_category_ids = array_remove(karate_category_ids, $1) 
+					where id = any($2);`, team.CategoryId, pq.Array(memberIds))
+	if err != nil {
+		return fmt.Errorf("dropGroupKata failed: %w", err)
+	}
+	return nil
+}
+
+// registeredParticipant - уже зарегистрированный на соревнование участник.
+type registeredParticipant struct {
+	id        int64
+	birthDate time.Time
+}
+
+// registeredParticipants - зарегистрированные участники по ParticipantKey.
+type registeredParticipants map[string][]registeredParticipant
 
+// find ищет среди зарегистрированных участника p и возвращает его id.
+func (r registeredParticipants) find(p Participant) (int64, bool) {
+	for _, c := range r[ParticipantKey(p.FullName, p.Age)] {
+		if SameBirthDate(c.birthDate, p.BirthDate) {
+			return c.id, true
+		}
 	}
+	return 0, false
+}
+
+// registeredParticipants возвращает уже зарегистрированных на соревнование участников. Возраст в БД записан
+// на день соревнований, как и возраст в ParticipantKey.
+func (s *Service) registeredParticipants(tx pgx.Tx, competId int64) (registeredParticipants, error) {
+	rows, err := tx.Query(s.ctx, `select id, fullname, age, birth_date from karate_participant 
+					where competition_id = $1;`, competId)
 	if err != nil {
-		return 0, fmt.Errorf("idFinderGroupKata failed: %w", err)
+		return nil, fmt.Errorf("registeredParticipants failed: %w", err)
+	}
+	defer rows.Close()
+
+	m := make(registeredParticipants)
+	for rows.Next() {
+		var (
+			id        int64
+			fullName  string
+			age       int32
+			birthDate *time.Time
+		)
+		if err := rows.Scan(&id, &fullName, &age, &birthDate); err != nil {
+			return nil, fmt.Errorf("registeredParticipants failed: %w", err)
+		}
+
+		r := registeredParticipant{id: id}
+		if birthDate != nil {
+			r.birthDate = *birthDate
+		}
+		key := ParticipantKey(fullName, uint8(age))
+		m[key] = append(m[key], r)
 	}
 
-	id := s.categories["кат"][ageRange]["о"].categoryId
+	return m, rows.Err()
+}
+
+func (s *Service) idFinderKata(resolver *CategoryResolver, p *Participant) (int, error) {
+	id, err := resolver.Kata(p)
+	if err != nil {
+		return 0, fmt.Errorf("idFinderKata failed: %w", err)
+	}
 	return id, nil
 }
 
-func (s *Service) idFinderKumite(p *Participant) (int, error) {
-	if !(p.Category.Upper.Int == 0) && (int32(p.Weight) > p.Category.Upper.Int || int32(p.Weight) < p.Category.Lower.Int) {
-		fmt.Println(p.FullName, " ", p.Category.Upper.Int)
-		return 0, errors.New("idFinderKumite: weight of participant is out his category")
+func (s *Service) idFinderGroupKata(resolver *CategoryResolver, p *Participant) (int, error) {
+	id, err := resolver.GroupKata(p)
+	if err != nil {
+		return 0, fmt.Errorf("idFinderGroupKata failed: %w", err)
 	}
+	return id, nil
+}
+
+// idFinderKumite ищет среди весовых категорий для возраста и пола участника ту, что указана в заявке. В заявке
+// категорию часто пишут только по верхней границе ("-45"), поэтому совпадение ищется по указанным границам.
+// Если указанной категории соответствует несколько категорий соревнования, участник не регистрируется.
+func (s *Service) idFinderKumite(resolver *CategoryResolver, p *Participant) (int, error) {
+	categories := resolver.Kumite(p)
 
-	ageRange := pgtype.Int4range{}
-	var err error
-	switch p.Age {
-	case 12, 13:
-		err = ageRange.Set("[12,14)")
-	case 14, 15:
-		err = ageRange.Set("[14,16)")
-	case 16, 17:
-		err = ageRange.Set("[16,18)")
+	if len(categories) == 0 {
+		return 0, fmt.Errorf("idFinderKumite failed: %w", noCategoryError(p, DISCIPLINE_KUMITE, false,
+			ErrCategoryNotFound))
+	}
+	catErr := &CategoryError{Declared: p.Category, Weight: p.Weight, Err: ErrCategoryNotFound}
+	for _, c := range categories {
+		catErr.Available = append(catErr.Available, FormatWeightCategory(c.Weight))
+	}
+	if p.Category.Status != pgtype.Present {
+		catErr.Err = ErrCategoryNotSpecified
+		return 0, fmt.Errorf("idFinderKumite failed: %w", catErr)
+	}
+
+	matched := make([]Category, 0, 1)
+	for _, c := range categories {
+		if categoryMatches(p.Category, c.Weight) {
+			matched = append(matched, c)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return 0, fmt.Errorf("idFinderKumite failed: %w", catErr)
+	case 1:
 	default:
-		err = ageRange.Set("[18,)")
+		catErr.Available = catErr.Available[:0]
+		for _, c := range matched {
+			catErr.Available = append(catErr.Available, FormatWeightCategory(c.Weight))
+		}
+		catErr.Err = ErrCategoryAmbiguous
+		return 0, fmt.Errorf("idFinderKumite failed: %w", catErr)
+	}
 
+	if !weightFits(p.Weight, matched[0].Weight) {
+		catErr.Declared = matched[0].Weight
+		catErr.Err = ErrWeightOutOfCategory
+		return 0, fmt.Errorf("idFinderKumite failed: %w", catErr)
 	}
-	if err != nil {
-		return 0, fmt.Errorf("idFinderKumite failed: %w", err)
+	return matched[0].Id, nil
+}
+
+// assignKumiteCategory подбирает участнику весовую категорию по весу. Указанная в заявке категория остаётся, если
+// вес в неё попадает: категории соседствуют границами ("35-40", "40-45"). Иначе участник попадает в меньшую
+// из подходящих по весу.
+func (s *Service) assignKumiteCategory(resolver *CategoryResolver, p *Participant) (CategoryAssignment, error) {
+	categories := resolver.Kumite(p)
+
+	if len(categories) == 0 {
+		return CategoryAssignment{}, fmt.Errorf("assignKumiteCategory failed: %w", noCategoryError(p,
+			DISCIPLINE_KUMITE, false, ErrCategoryNotFound))
+	}
+	catErr := &CategoryError{Weight: p.Weight, Err: ErrCategoryNotFound}
+
+	if p.Category.Status == pgtype.Present {
+		for _, c := range categories {
+			if categoryMatches(p.Category, c.Weight) && weightFits(p.Weight, c.Weight) {
+				a := CategoryAssignment{Participant: *p, Declared: p.Category, Assigned: c.Weight}
+				p.Category = c.Weight
+				return a, nil
+			}
+		}
 	}
 
-	id := s.categories["кум"][ageRange][p.Sex].weightMap[p.Category]
+	for _, c := range categories {
+		if weightFits(p.Weight, c.Weight) {
+			a := CategoryAssignment{Participant: *p, Declared: p.Category, Assigned: c.Weight}
+			p.Category = c.Weight
+			return a, nil
+		}
+		catErr.Available = append(catErr.Available, FormatWeightCategory(c.Weight))
+	}
 
-	return id, nil
+	return CategoryAssignment{}, fmt.Errorf("assignKumiteCategory failed: %w", catErr)
 }
 
-func (s *Service) getCategoryIds(ids *[]int, p *Participant, categories [3]bool) error {
+// categoryMatches сравнивает категорию из заявки с категорией из karate_category. Незаданная в заявке нижняя
+// граница совпадает с любой, верхняя должна совпадать всегда: "-45" - это и "40-45", и "до 45".
+func categoryMatches(declared, w pgtype.Int4range) bool {
+	if declared.UpperType == pgtype.Unbounded {
+		if w.UpperType != pgtype.Unbounded {
+			return false
+		}
+	} else if w.UpperType == pgtype.Unbounded || inclusiveUpper(w) != inclusiveUpper(declared) {
+		return false
+	}
+
+	return declared.LowerType == pgtype.Unbounded ||
+		w.LowerType != pgtype.Unbounded && w.Lower.Int == declared.Lower.Int
+}
+
+// weightFits проверяет, что вес попадает в категорию. Границы категорий включительные: "40-45" - от 40 до 45 кг.
+func weightFits(weight float32, w pgtype.Int4range) bool {
+	if w.LowerType != pgtype.Unbounded && weight < float32(w.Lower.Int) {
+		return false
+	}
+	return w.UpperType == pgtype.Unbounded || weight <= float32(inclusiveUpper(w))
+}
+
+func (s *Service) getCategoryIds(resolver *CategoryResolver, ids *[]int, p *Participant, categories [3]bool) error {
 	// categories[] = {kata: true/false, kataGroup: true/false, kumite: true/false}
 	if categories[0] {
-		id, err := s.idFinderKata(p)
+		id, err := s.idFinderKata(resolver, p)
 		if err != nil {
 			return fmt.Errorf("getCategoryIds failed: %w", err)
 		}
@@ -293,7 +982,7 @@ func (s *Service) getCategoryIds(ids *[]int, p *Participant, categories [3]bool)
 	}
 
 	if categories[1] {
-		id, err := s.idFinderGroupKata(p)
+		id, err := s.idFinderGroupKata(resolver, p)
 		if err != nil {
 			return fmt.Errorf("getCategoryIds failed: %w", err)
 		}
@@ -302,7 +991,7 @@ func (s *Service) getCategoryIds(ids *[]int, p *Participant, categories [3]bool)
 	}
 
 	if categories[2] {
-		id, err := s.idFinderKumite(p)
+		id, err := s.idFinderKumite(resolver, p)
 		if err != nil {
 			return fmt.Errorf("getCategoryIds failed: %w", err)
 		}
@@ -312,3 +1001,19 @@ func (s *Service) getCategoryIds(ids *[]int, p *Participant, categories [3]bool)
 
 	return nil
 }
+
+// nullIfEmpty нужен для необязательных текстовых полей: пустая строка записывается в БД как NULL.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// nullIfZero записывает незаполненную дату в БД как NULL.
+func nullIfZero(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
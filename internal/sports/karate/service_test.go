@@ -0,0 +1,82 @@
+package karate
+
+import (
+	"testing"
+	"time"
+)
+
+// Строгое соревнование принимает заявку только целиком: отменяет её любая ошибка в строках, а не только ошибка записи.
+func TestUploadModeRollsBack(t *testing.T) {
+	resolver := testResolver(t)
+	kata := func(row int, name string, age uint8) Participant {
+		return Participant{Row: row, FullName: name, Age: age, Sex: SEX_MALE, Kyi: 8, KataKumite: [2]bool{true, false}}
+	}
+	duplicate := kata(2, "Петров Пётр", 13)
+	teammate := kata(2, "Петров Пётр", 13)
+	teammate.KataGroup = true
+	teammate.KataTeam = "Вихрь"
+
+	tests := []struct {
+		name       string
+		parts      []Participant
+		registered registeredParticipants
+		writeErrs  map[int]error
+		want       bool
+	}{
+		{name: "valid", parts: []Participant{kata(1, "Иванов Иван", 13)}},
+		{name: "no_category", parts: []Participant{kata(1, "Иванов Иван", 13), kata(2, "Петров Пётр", 8)}, want: true},
+		{name: "duplicate", parts: []Participant{kata(1, "Иванов Иван", 13), duplicate},
+			registered: registeredParticipants{ParticipantKey(duplicate.FullName, duplicate.Age): {{id: 10}}}, want: true},
+		{name: "incomplete_team", parts: []Participant{kata(1, "Иванов Иван", 13), teammate}, want: true},
+		{name: "write_error", parts: []Participant{kata(1, "Иванов Иван", 13)},
+			writeErrs: map[int]error{0: &WriteError{}}, want: true},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{}
+			resp := &Response{}
+			_, teamErrs := s.kataTeams(resolver, tt.parts)
+			s.prepareWrites(resp, resolver, tt.parts, teamErrs, tt.registered, Club{}, false, false)
+
+			if got := UPLOAD_ATOMIC.rollsBack(resp, tt.writeErrs); got != tt.want {
+				t.Errorf("UPLOAD_ATOMIC.rollsBack = %v, want %v, response %+v", got, tt.want, resp)
+			}
+			if UPLOAD_PARTIAL.rollsBack(resp, tt.writeErrs) {
+				t.Errorf("UPLOAD_PARTIAL.rollsBack = true, want false")
+			}
+		})
+	}
+}
+
+// Участник, присланный сначала с возрастом, а потом с датой рождения, - тот же участник. Тёзки одного возраста
+// с разными датами рождения - разные.
+func TestRegisteredParticipantsFind(t *testing.T) {
+	born := time.Date(2011, time.May, 5, 0, 0, 0, 0, time.UTC)
+	registered := registeredParticipants{
+		ParticipantKey("Иванов Иван", 13):  {{id: 1}},
+		ParticipantKey("Петров Пётр", 13):  {{id: 2, birthDate: born}},
+		ParticipantKey("Сидоров Олег", 12): {{id: 3, birthDate: born}},
+		ParticipantKey("Иванова Алла", 12): {{id: 4, birthDate: born}},
+	}
+
+	tests := []struct {
+		name   string
+		p      Participant
+		wantId int64
+	}{
+		{name: "resend_with_birth_date", p: Participant{FullName: "иванов  иван", Age: 13, BirthDate: born}, wantId: 1},
+		{name: "resend_with_age", p: Participant{FullName: "Петров Петр", Age: 13}, wantId: 2},
+		{name: "same_birth_date", p: Participant{FullName: "Петров Пётр", Age: 13, BirthDate: born}, wantId: 2},
+		{name: "namesake", p: Participant{FullName: "Петров Пётр", Age: 13, BirthDate: born.AddDate(0, 1, 0)}},
+		{name: "other_age", p: Participant{FullName: "Иванов Иван", Age: 14}},
+		{name: "double_letter_same_birth_date", p: Participant{FullName: "Иванова Алла", Age: 12, BirthDate: born}, wantId: 4},
+		{name: "double_letter_other_birth_date", p: Participant{FullName: "Иванова Ала", Age: 12, BirthDate: born.AddDate(0, 0, 3)}},
+	}
+	for _, tt := range tests {
+		id, ok := registered.find(tt.p)
+		if id != tt.wantId || ok != (tt.wantId != 0) {
+			t.Errorf("%s: find = %d, %v, want %d", tt.name, id, ok, tt.wantId)
+		}
+	}
+}
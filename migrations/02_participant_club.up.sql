@@ -0,0 +1,5 @@
+-- Клуб и контакты представителя команды, подавшей заявку
+alter table karate_participant
+    add column club text,
+    add column contact_email mail,
+    add column contact_phone varchar(16);
@@ -0,0 +1,5 @@
+-- Настройки приёма заявок: допустимый процент строк с ошибками и строгий режим, в котором заявка с любой
+-- ошибкой отклоняется целиком
+alter table competition
+    add column error_threshold float8 not null default 50 check ( error_threshold >= 0 and error_threshold <= 100 ),
+    add column strict_upload boolean not null default false;
@@ -0,0 +1,3 @@
+-- Дата рождения участника. Возраст на день соревнований считается по ней, если она указана в заявке
+alter table karate_participant
+    add column birth_date date;
@@ -0,0 +1,9 @@
+-- ФИО участника по частям, латиницей и ключ для поиска одного и того же спортсмена в разных соревнованиях
+alter table karate_participant
+    add column surname text,
+    add column first_name text,
+    add column patronymic text,
+    add column fullname_latin text,
+    add column fullname_key text;
+
+create index karate_participant_fullname_key_idx on karate_participant (fullname_key);
@@ -0,0 +1,3 @@
+-- Автоматический подбор весовой категории кумите по весу участника вместо указанной в заявке
+alter table competition
+    add column auto_kumite_category boolean not null default false;
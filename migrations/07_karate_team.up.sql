@@ -0,0 +1,17 @@
+-- Команды группового ката. Название команды уникально в пределах клуба на соревновании, участник может быть
+-- только в одной команде
+create table karate_team (
+    id bigserial not null primary key,
+    competition_id bigint references competition(id) not null,
+    karate_category_id integer references karate_category(id) not null,
+    name text not null,
+    club text not null default '',
+    unique (competition_id, club, name)
+);
+
+create table karate_team_member (
+    team_id bigint references karate_team(id) on delete cascade not null,
+    participant_id bigint references karate_participant(id) on delete cascade not null primary key
+);
+
+create index karate_team_member_team_id_idx on karate_team_member (team_id);
@@ -0,0 +1,12 @@
+-- Сервис держит категории в памяти и перечитывает их по уведомлению, а не только по расписанию.
+-- Триггер срабатывает один раз на запрос: массовое изменение категорий даёт одно уведомление, а не по строке
+create or replace function karate_category_notify() returns trigger as $$
+begin
+    perform pg_notify('karate_category_changed', tg_op);
+    return null;
+end;
+$$ language plpgsql;
+
+create trigger karate_category_changed
+    after insert or update or delete or truncate on karate_category
+    for each statement execute procedure karate_category_notify();
@@ -0,0 +1,14 @@
+-- Набор категорий соревнования. Соревнование без строк в этой таблице использует все категории karate_category,
+-- поэтому уже созданные соревнования работают как раньше
+create table competition_category (
+    competition_id bigint references competition(id) on delete cascade not null,
+    karate_category_id integer references karate_category(id) on delete cascade not null,
+    primary key (competition_id, karate_category_id)
+);
+
+create index competition_category_karate_category_id_idx on competition_category (karate_category_id);
+
+-- Сервис перечитывает категории и при изменении набора категорий соревнования, см. 08_karate_category_notify.up.sql
+create trigger competition_category_changed
+    after insert or update or delete or truncate on competition_category
+    for each statement execute procedure karate_category_notify();
@@ -0,0 +1,6 @@
+-- Соревнование, которому принадлежит копия категории (см. CopyCategories с clone = true). Категории без владельца
+-- общие: их используют соревнования без своего набора, копии в этот набор не попадают
+alter table karate_category
+    add column owner_competition_id bigint references competition(id) on delete cascade;
+
+create index karate_category_owner_competition_id_idx on karate_category (owner_competition_id);